@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNetwork parses a CIDR such as "192.168.1.0/24", or a single address
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		if ip := net.ParseIP(value); ip != nil {
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// allowlistHandler rejects the requests which do not come from one of the
+// networks. The address of the connection is used, X-Forwarded-For is ignored
+func allowlistHandler(networks []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		logDebug("Rejected", r.Method, r.URL.Path, "from", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
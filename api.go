@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+type apiRoutes struct {
+	Routes []apiPath `json:"routes"`
+}
+
+type apiPath struct {
+	Path            string      `json:"path"`
+	From            string      `json:"from"`
+	To              string      `json:"to"`
+	FromCoordinates string      `json:"from_coordinates"`
+	ToCoordinates   string      `json:"to_coordinates"`
+	Options         apiOptions  `json:"options"`
+	LastUpdate      *time.Time  `json:"last_update"`
+	Primary         *apiRoute   `json:"primary"`
+	Alternatives    []*apiRoute `json:"alternatives"`
+}
+
+type apiOptions struct {
+	Region                string            `json:"region"`
+	Vehicle               string            `json:"vehicle"`
+	AvoidToll             bool              `json:"avoid_toll"`
+	AvoidSubscriptionRoad bool              `json:"avoid_subscription_road"`
+	AvoidFerry            bool              `json:"avoid_ferry"`
+	AvoidUnpaved          bool              `json:"avoid_unpaved"`
+	ExtraOptions          map[string]string `json:"extra_options,omitempty"`
+	ExtraParams           map[string]string `json:"extra_params,omitempty"`
+}
+
+type apiRoute struct {
+	Name            string       `json:"name"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	DistanceMeters  int          `json:"distance_meters"`
+	Toll            bool         `json:"toll"`
+	Closures        int          `json:"closures"`
+	Segments        []apiSegment `json:"segments"`
+}
+
+type apiSegment struct {
+	Street           string  `json:"street"`
+	Lon              float64 `json:"lon"`
+	Lat              float64 `json:"lat"`
+	LengthMeters     int     `json:"length_meters"`
+	CrossTimeSeconds float64 `json:"cross_time_seconds"`
+	Toll             bool    `json:"toll"`
+	Closed           bool    `json:"closed"`
+}
+
+func newAPIRoute(result *waze.Result) *apiRoute {
+	route := &apiRoute{
+		Name:            result.Name,
+		DurationSeconds: result.Duration.Seconds(),
+		DistanceMeters:  result.Distance,
+		Toll:            result.Toll,
+		Closures:        result.Closures,
+		Segments:        []apiSegment{},
+	}
+	for _, segment := range result.Segments {
+		route.Segments = append(route.Segments, apiSegment{
+			Street:           segment.Street,
+			Lon:              segment.Lon,
+			Lat:              segment.Lat,
+			LengthMeters:     segment.Length,
+			CrossTimeSeconds: segment.CrossTime.Seconds(),
+			Toll:             segment.Toll,
+			Closed:           segment.Closed,
+		})
+	}
+	return route
+}
+
+// routesHandler serves the last routes of all the paths, or only the paths
+// given by the "paths" parameter as /metrics does. It never calls Waze API
+func (c *context) routesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected := c
+		if paths := r.URL.Query().Get("paths"); paths != "" {
+			filtered, err := c.filter(strings.Split(paths, ","))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			selected = filtered
+		}
+
+		response := apiRoutes{Routes: []apiPath{}}
+		for _, metric := range selected.wazeMetrics {
+			parameters := &metric.wazeParameters
+			path := apiPath{
+				Path:            metric.name,
+				From:            metric.from,
+				To:              metric.to,
+				FromCoordinates: parameters.FromCoordinates,
+				ToCoordinates:   parameters.ToCoordinates,
+				Options: apiOptions{
+					Region:                parameters.Region.String(),
+					Vehicle:               parameters.Vehicle.String(),
+					AvoidToll:             parameters.AvoidToll,
+					AvoidSubscriptionRoad: parameters.AvoidSubscriptionRoad,
+					AvoidFerry:            parameters.AvoidFerry,
+					AvoidUnpaved:          parameters.AvoidUnpaved,
+					ExtraOptions:          parameters.ExtraOptions,
+					ExtraParams:           parameters.ExtraParams,
+				},
+				Alternatives: []*apiRoute{},
+			}
+			if lastUpdate := metric.getLastUpdate(); !lastUpdate.IsZero() {
+				path.LastUpdate = &lastUpdate
+			}
+			if results, ok := metric.lastResults.Load().([]waze.Result); ok {
+				path.Primary = newAPIRoute(&results[0])
+				for i := range results[1:] {
+					path.Alternatives = append(path.Alternatives, newAPIRoute(&results[i+1]))
+				}
+			}
+			response.Routes = append(response.Routes, path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
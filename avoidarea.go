@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+// point is [lon, lat], as the coordinates of the addresses
+type point [2]float64
+
+// avoidArea is a polygon that the routes should not cross
+type avoidArea struct {
+	name      string
+	polygon   []point
+	violation prometheus.Gauge
+}
+
+func newPolygon(coordinates Polygon) []point {
+	result := make([]point, 0, len(coordinates))
+	for _, coordinate := range coordinates {
+		result = append(result, point{coordinate[0], coordinate[1]})
+	}
+	return result
+}
+
+// contains uses the ray casting algorithm
+func (a *avoidArea) contains(p point) bool {
+	inside := false
+	for i, j := 0, len(a.polygon)-1; i < len(a.polygon); j, i = i, i+1 {
+		pi, pj := a.polygon[i], a.polygon[j]
+		if (pi[1] > p[1]) != (pj[1] > p[1]) &&
+			p[0] < (pj[0]-pi[0])*(p[1]-pi[1])/(pj[1]-pi[1])+pi[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// crosses returns true if the segment from p to q crosses an edge of the
+// polygon
+func (a *avoidArea) crosses(p, q point) bool {
+	for i, j := 0, len(a.polygon)-1; i < len(a.polygon); j, i = i, i+1 {
+		if segmentsIntersect(p, q, a.polygon[j], a.polygon[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func segmentsIntersect(p1, p2, q1, q2 point) bool {
+	orientation := func(a, b, c point) float64 {
+		return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	}
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) &&
+		((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// intersects returns true if the geometry of the route goes through the area
+func (a *avoidArea) intersects(result *waze.Result) bool {
+	for i, segment := range result.Segments {
+		p := point{segment.Lon, segment.Lat}
+		if a.contains(p) {
+			return true
+		}
+		if i > 0 {
+			previous := result.Segments[i-1]
+			if a.crosses(point{previous.Lon, previous.Lat}, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// backfillHandler serves the travel times kept in the history as an
+// OpenMetrics file, to be imported in Prometheus with
+// promtool tsdb create-blocks-from openmetrics. As /metrics, it accepts the
+// "paths" parameter
+func (c *context) backfillHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected := c
+		if paths := r.URL.Query().Get("paths"); paths != "" {
+			filtered, err := c.filter(strings.Split(paths, ","))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			selected = filtered
+		}
+		series := []backfillSeries{}
+		for _, metric := range selected.wazeMetrics {
+			if metric.history != nil {
+				series = append(series, backfillSeries{
+					from:    metric.from,
+					to:      metric.to,
+					samples: metric.history.since(time.Time{}),
+				})
+			}
+		}
+		if len(series) == 0 {
+			http.Error(w, "No history, quantile_window or last_week must be set", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		writeOpenMetrics(w, series)
+	})
+}
+
+// backfillSeries is the history of the travel time of a path
+type backfillSeries struct {
+	from    string
+	to      string
+	samples []historySample
+}
+
+func writeOpenMetrics(w io.Writer, series []backfillSeries) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# HELP %s travel time in seconds\n", travelTimeName)
+	fmt.Fprintf(out, "# TYPE %s gauge\n", travelTimeName)
+	for _, s := range series {
+		labels := fmt.Sprintf(`{from="%s",to="%s"}`, openMetricsEscaper.Replace(s.from), openMetricsEscaper.Replace(s.to))
+		for _, sample := range s.samples {
+			fmt.Fprintf(out, "%s%s %s %s\n", travelTimeName, labels,
+				strconv.FormatFloat(sample.Value, 'f', -1, 64),
+				strconv.FormatFloat(float64(sample.Time.UnixNano())/1e9, 'f', 3, 64))
+		}
+	}
+	fmt.Fprintln(out, "# EOF")
+	return out.Flush()
+}
+
+// backfillCommand gets the history of the exporter running with the given
+// configuration from its snapshot and writes it as an OpenMetrics file
+func backfillCommand(configFile string, outputFile string) {
+	jsonConfig := NewConfig(configFile)
+	initMetrics(jsonConfig.MetricNames)
+	snapshot := &state{}
+	if err := json.Unmarshal(getSnapshot(jsonConfig), snapshot); err != nil {
+		log.Fatalln("Invalid snapshot", err)
+	}
+
+	series := []backfillSeries{}
+	samples := 0
+	for _, path := range jsonConfig.Paths {
+		if statePath := snapshot.Paths[path.Name]; statePath != nil && len(statePath.History) > 0 {
+			series = append(series, backfillSeries{
+				from:    path.From,
+				to:      path.To,
+				samples: statePath.History,
+			})
+			samples += len(statePath.History)
+		}
+	}
+	if len(series) == 0 {
+		log.Fatalln("No history, quantile_window or last_week must be set")
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := writeOpenMetrics(file, series); err != nil {
+		file.Close()
+		log.Fatalln(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println(samples, "samples of", len(series), "paths written to", outputFile)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+// resultCache shares the last results of the paths between the replicas
+// thanks to Redis. The entries expire after the TTL so the replicas call Waze
+// API at most once per TTL for each path
+type resultCache struct {
+	client *redisClient
+	prefix string
+	ttl    time.Duration
+}
+
+type resultCacheEntry struct {
+	Time    time.Time     `json:"time"`
+	Results []waze.Result `json:"results"`
+}
+
+func newResultCache(client *redisClient, prefix string, ttl time.Duration) *resultCache {
+	return &resultCache{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func (r *resultCache) get(name string) (*resultCacheEntry, bool) {
+	reply, err := r.client.Do("GET", r.prefix+name)
+	if err != nil {
+		log.Println("Cannot read the cache", err)
+		return nil, false
+	}
+	value, found := reply.(string)
+	if !found {
+		return nil, false
+	}
+	entry := &resultCacheEntry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		log.Println("Cannot decode the cache entry", name, err)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (r *resultCache) set(name string, entry *resultCacheEntry) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Cannot encode the cache entry", name, err)
+		return
+	}
+	if _, err := r.client.Do("SET", r.prefix+name, string(value), "PX", strconv.FormatInt(r.ttl.Milliseconds(), 10)); err != nil {
+		log.Println("Cannot write the cache", err)
+	}
+}
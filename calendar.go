@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type calendarEvent struct {
+	summary string
+	start   time.Time
+	end     time.Time
+	// rule is the recurrence rule which could not be expanded, if any. Only
+	// the first occurrence of such an event is used
+	rule string
+}
+
+// calendarHorizon is how far the recurring events are expanded. The calendar
+// is reloaded long before
+const calendarHorizon = 366 * 24 * time.Hour
+
+// calendar holds the events of an iCalendar file or URL, reloaded periodically
+type calendar struct {
+	source string
+	client *http.Client
+	mutex  sync.RWMutex
+	events []calendarEvent
+}
+
+func newCalendar(source string, client *http.Client) (*calendar, error) {
+	c := &calendar{
+		source: source,
+		client: client,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *calendar) load() error {
+	var content []byte
+	var err error
+	if strings.HasPrefix(c.source, "http://") || strings.HasPrefix(c.source, "https://") {
+		content, err = c.download()
+	} else {
+		content, err = os.ReadFile(c.source)
+	}
+	if err != nil {
+		return err
+	}
+	events, err := decodeICalendar(content, time.Now())
+	if err != nil {
+		return err
+	}
+	logDebug("Load", len(events), "events from", c.source)
+	for _, event := range events {
+		if event.rule != "" {
+			log.Println("Unsupported recurrence rule", event.rule, "of the event", event.summary, "only its first occurrence is used")
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = events
+	return nil
+}
+
+func (c *calendar) download() ([]byte, error) {
+	resp, err := c.client.Get(c.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Got HTTP %d %s", resp.StatusCode, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// watch reloads the calendar every interval. On error, the previous events
+// are kept
+func (c *calendar) watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := c.load(); err != nil {
+			log.Println("Could not reload the calendar", c.source, err)
+		}
+	}
+}
+
+// ongoing returns true if an event whose summary matches is ongoing
+func (c *calendar) ongoing(t time.Time, pattern *regexp.Regexp) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, event := range c.events {
+		if !t.Before(event.start) && t.Before(event.end) && pattern.MatchString(event.summary) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupported returns the summary of an event matching the pattern whose
+// recurrence rule could not be expanded, if any
+func (c *calendar) unsupported(pattern *regexp.Regexp) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, event := range c.events {
+		if event.rule != "" && pattern.MatchString(event.summary) {
+			return event.summary
+		}
+	}
+	return ""
+}
+
+// icalEvent is a VEVENT before the expansion of its recurrence
+type icalEvent struct {
+	calendarEvent
+	uid          string
+	rrule        string
+	exdates      map[int64]bool
+	recurrenceID time.Time
+}
+
+// decodeICalendar reads the VEVENT of an iCalendar (RFC 5545). The recurring
+// events are expanded from now to the horizon, see expand
+func decodeICalendar(content []byte, now time.Time) ([]calendarEvent, error) {
+	// unfold the lines starting with a space or a tab
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	events := []*icalEvent{}
+	var event *icalEvent
+	allDay := false
+	for _, line := range lines {
+		separator := strings.Index(line, ":")
+		if separator < 0 {
+			continue
+		}
+		params := strings.Split(line[:separator], ";")
+		name := strings.ToUpper(params[0])
+		value := line[separator+1:]
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &icalEvent{exdates: map[int64]bool{}}
+			allDay = false
+		case event == nil:
+		case name == "END" && value == "VEVENT":
+			if event.end.IsZero() && allDay {
+				event.end = event.start.AddDate(0, 0, 1)
+			}
+			if !event.start.IsZero() && event.end.After(event.start) {
+				events = append(events, event)
+			}
+			event = nil
+		case name == "SUMMARY":
+			event.summary = strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+		case name == "DTSTART" || name == "DTEND":
+			t, date, err := decodeICalendarTime(params[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			if name == "DTSTART" {
+				event.start = t
+				allDay = date
+			} else {
+				event.end = t
+			}
+		case name == "UID":
+			event.uid = value
+		case name == "RRULE":
+			event.rrule = value
+		case name == "EXDATE":
+			for _, exdate := range strings.Split(value, ",") {
+				t, _, err := decodeICalendarTime(params[1:], exdate)
+				if err != nil {
+					return nil, err
+				}
+				event.exdates[t.Unix()] = true
+			}
+		case name == "RECURRENCE-ID":
+			t, _, err := decodeICalendarTime(params[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			event.recurrenceID = t
+		}
+	}
+
+	// an event with a RECURRENCE-ID replaces an occurrence of the recurring
+	// event with the same UID
+	overridden := map[string][]time.Time{}
+	for _, event := range events {
+		if !event.recurrenceID.IsZero() {
+			overridden[event.uid] = append(overridden[event.uid], event.recurrenceID)
+		}
+	}
+	result := []calendarEvent{}
+	for _, event := range events {
+		if event.rrule == "" || !event.recurrenceID.IsZero() {
+			result = append(result, event.calendarEvent)
+			continue
+		}
+		for _, t := range overridden[event.uid] {
+			event.exdates[t.Unix()] = true
+		}
+		occurrences, err := event.expand(now, now.Add(calendarHorizon))
+		if err != nil {
+			logDebug("Cannot expand", event.summary, err)
+			event.rule = event.rrule
+			result = append(result, event.calendarEvent)
+			continue
+		}
+		result = append(result, occurrences...)
+	}
+	return result, nil
+}
+
+// expand returns the occurrences of a recurring event which end after since
+// and start before until. FREQ may be DAILY, WEEKLY, MONTHLY or YEARLY, with
+// INTERVAL, COUNT, UNTIL and for WEEKLY only BYDAY, without any ordinal
+func (e *icalEvent) expand(since time.Time, until time.Time) ([]calendarEvent, error) {
+	frequency := ""
+	interval := 1
+	count := 0
+	weekdays := []int{} // days since Monday
+	for _, part := range strings.Split(e.rrule, ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		var err error
+		switch value := keyValue[1]; strings.ToUpper(keyValue[0]) {
+		case "FREQ":
+			frequency = strings.ToUpper(value)
+		case "INTERVAL":
+			if interval, err = strconv.Atoi(value); err == nil && interval <= 0 {
+				err = fmt.Errorf("invalid interval %d", interval)
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(value)
+		case "UNTIL":
+			var t time.Time
+			if t, _, err = decodeICalendarTime([]string{"TZID=" + e.start.Location().String()}, value); err == nil && t.Before(until) {
+				until = t.Add(time.Second)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday := strings.Index("MOTUWETHFRSASU", strings.ToUpper(day))
+				if len(day) != 2 || weekday < 0 || weekday%2 != 0 {
+					return nil, fmt.Errorf("unsupported BYDAY %s", value)
+				}
+				weekdays = append(weekdays, weekday/2)
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("unsupported %s", part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(weekdays) > 0 && frequency != "WEEKLY" {
+		return nil, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+
+	// the candidates of the period i, in chronological order
+	startWeekday := (int(e.start.Weekday()) + 6) % 7
+	var period func(i int) []time.Time
+	valid := func(t time.Time) bool { return true }
+	switch frequency {
+	case "DAILY":
+		period = func(i int) []time.Time {
+			return []time.Time{e.start.AddDate(0, 0, i*interval)}
+		}
+	case "WEEKLY":
+		if len(weekdays) == 0 {
+			weekdays = append(weekdays, startWeekday)
+		}
+		sort.Ints(weekdays)
+		period = func(i int) []time.Time {
+			monday := e.start.AddDate(0, 0, 7*i*interval-startWeekday)
+			result := []time.Time{}
+			for _, weekday := range weekdays {
+				result = append(result, monday.AddDate(0, 0, weekday))
+			}
+			return result
+		}
+	case "MONTHLY", "YEARLY":
+		months := interval
+		if frequency == "YEARLY" {
+			months *= 12
+		}
+		period = func(i int) []time.Time {
+			return []time.Time{e.start.AddDate(0, i*months, 0)}
+		}
+		// the invalid dates such as February 30 are normalized by AddDate, they
+		// are skipped
+		valid = func(t time.Time) bool { return t.Day() == e.start.Day() }
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %s", frequency)
+	}
+
+	duration := e.end.Sub(e.start)
+	result := []calendarEvent{}
+	occurrences := 0
+	for i := 0; ; i++ {
+		for _, start := range period(i) {
+			if start.Before(e.start) {
+				continue
+			}
+			if !start.Before(until) || (count > 0 && occurrences >= count) {
+				return result, nil
+			}
+			if !valid(start) {
+				continue
+			}
+			// the excluded occurrences are counted as well
+			occurrences++
+			if !e.exdates[start.Unix()] && start.Add(duration).After(since) {
+				result = append(result, calendarEvent{summary: e.summary, start: start, end: start.Add(duration)})
+			}
+		}
+	}
+}
+
+// unknownTimezones are the TZID already reported as unknown
+var unknownTimezones sync.Map
+
+// decodeICalendarTime returns the time and whether it is a date only. An
+// unknown TZID, such as the Windows names used by Outlook, is in local time
+func decodeICalendarTime(params []string, value string) (time.Time, bool, error) {
+	location := time.Local
+	for _, param := range params {
+		if strings.HasPrefix(strings.ToUpper(param), "TZID=") {
+			tzid := strings.Trim(param[5:], `"`)
+			if tz, err := time.LoadLocation(tzid); err == nil {
+				location = tz
+			} else if _, reported := unknownTimezones.LoadOrStore(tzid, true); !reported {
+				log.Println("Unknown time zone", tzid, "in the calendar, local time is used instead")
+			}
+		}
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	if len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, location)
+		return t, true, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	return t, false, err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeICalendar(t *testing.T) {
+	utc := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	type occurrence struct {
+		start time.Time
+		end   time.Time
+	}
+	tests := []struct {
+		name     string
+		event    string
+		expected []occurrence
+		rule     string
+	}{
+		{
+			name: "single",
+			event: `DTSTART:20260105T080000Z
+DTEND:20260105T090000Z`,
+			expected: []occurrence{{utc(1, 5, 8), utc(1, 5, 9)}},
+		},
+		{
+			name: "daily",
+			event: `DTSTART:20251230T080000Z
+DTEND:20251230T090000Z
+RRULE:FREQ=DAILY;COUNT=5`,
+			expected: []occurrence{
+				{utc(1, 1, 8), utc(1, 1, 9)},
+				{utc(1, 2, 8), utc(1, 2, 9)},
+				{utc(1, 3, 8), utc(1, 3, 9)},
+			},
+		},
+		{
+			name: "interval",
+			event: `DTSTART:20260105T080000Z
+DTEND:20260105T090000Z
+RRULE:FREQ=DAILY;INTERVAL=2;COUNT=3`,
+			expected: []occurrence{
+				{utc(1, 5, 8), utc(1, 5, 9)},
+				{utc(1, 7, 8), utc(1, 7, 9)},
+				{utc(1, 9, 8), utc(1, 9, 9)},
+			},
+		},
+		{
+			name: "weekly byday until",
+			event: `DTSTART:20260105T080000Z
+DTEND:20260105T090000Z
+RRULE:FREQ=WEEKLY;BYDAY=WE,MO;UNTIL=20260114T080000Z`,
+			expected: []occurrence{
+				{utc(1, 5, 8), utc(1, 5, 9)},
+				{utc(1, 7, 8), utc(1, 7, 9)},
+				{utc(1, 12, 8), utc(1, 12, 9)},
+				{utc(1, 14, 8), utc(1, 14, 9)},
+			},
+		},
+		{
+			name: "weekly count",
+			event: `DTSTART:20260106T080000Z
+DTEND:20260106T090000Z
+RRULE:FREQ=WEEKLY;BYDAY=MO,TU;COUNT=3`,
+			expected: []occurrence{
+				{utc(1, 6, 8), utc(1, 6, 9)},
+				{utc(1, 12, 8), utc(1, 12, 9)},
+				{utc(1, 13, 8), utc(1, 13, 9)},
+			},
+		},
+		{
+			name: "exdate",
+			event: `DTSTART:20260105T080000Z
+DTEND:20260105T090000Z
+RRULE:FREQ=DAILY;COUNT=3
+EXDATE:20260106T080000Z`,
+			expected: []occurrence{
+				{utc(1, 5, 8), utc(1, 5, 9)},
+				{utc(1, 7, 8), utc(1, 7, 9)},
+			},
+		},
+		{
+			name: "recurrence-id",
+			event: `UID:shift
+DTSTART:20260105T080000Z
+DTEND:20260105T090000Z
+RRULE:FREQ=DAILY;COUNT=3
+END:VEVENT
+BEGIN:VEVENT
+UID:shift
+RECURRENCE-ID:20260106T080000Z
+DTSTART:20260106T100000Z
+DTEND:20260106T110000Z`,
+			expected: []occurrence{
+				{utc(1, 5, 8), utc(1, 5, 9)},
+				{utc(1, 7, 8), utc(1, 7, 9)},
+				{utc(1, 6, 10), utc(1, 6, 11)},
+			},
+		},
+		{
+			name:  "all day",
+			event: `DTSTART;VALUE=DATE:20260110`,
+			expected: []occurrence{{
+				time.Date(2026, 1, 10, 0, 0, 0, 0, time.Local),
+				time.Date(2026, 1, 11, 0, 0, 0, 0, time.Local),
+			}},
+		},
+		{
+			name: "monthly on the 31st",
+			event: `DTSTART:20260131T080000Z
+DTEND:20260131T090000Z
+RRULE:FREQ=MONTHLY;COUNT=3`,
+			expected: []occurrence{
+				{utc(1, 31, 8), utc(1, 31, 9)},
+				{utc(3, 31, 8), utc(3, 31, 9)},
+				{utc(5, 31, 8), utc(5, 31, 9)},
+			},
+		},
+		{
+			name: "time zone",
+			event: `DTSTART;TZID=Europe/Paris:20260105T080000
+DTEND;TZID=Europe/Paris:20260105T090000`,
+			expected: []occurrence{{utc(1, 5, 7), utc(1, 5, 8)}},
+		},
+		{
+			name: "unknown time zone",
+			event: `DTSTART;TZID="W. Europe Standard Time":20260105T080000
+DTEND;TZID="W. Europe Standard Time":20260105T090000`,
+			expected: []occurrence{{
+				time.Date(2026, 1, 5, 8, 0, 0, 0, time.Local),
+				time.Date(2026, 1, 5, 9, 0, 0, 0, time.Local),
+			}},
+		},
+		{
+			name: "unsupported rule",
+			event: `DTSTART:20260105T080000Z
+DTEND:20260105T090000Z
+RRULE:FREQ=MONTHLY;BYDAY=1MO`,
+			expected: []occurrence{{utc(1, 5, 8), utc(1, 5, 9)}},
+			rule:     "FREQ=MONTHLY;BYDAY=1MO",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Shift\r\n" +
+				strings.ReplaceAll(test.event, "\n", "\r\n") +
+				"\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+			events, err := decodeICalendar([]byte(content), utc(1, 1, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(test.expected) {
+				t.Fatalf("got %d events %v, expected %d", len(events), events, len(test.expected))
+			}
+			for i, event := range events {
+				if !event.start.Equal(test.expected[i].start) || !event.end.Equal(test.expected[i].end) || event.rule != test.rule {
+					t.Errorf("event %d: got %s - %s %q, expected %s - %s %q", i,
+						event.start, event.end, event.rule,
+						test.expected[i].start, test.expected[i].end, test.rule)
+				}
+			}
+		})
+	}
+}
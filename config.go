@@ -2,41 +2,438 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
 )
 
 type Path struct {
-	From string `json:"from"`
-	To   string `json:"to"`
+	Name          string   `json:"name"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Timeout       int64    `json:"timeout"`
+	WeekdaysOnly  bool     `json:"weekdays_only"`
+	CalendarEvent string   `json:"calendar_event"`
+	CO2PerKm      float64  `json:"co2_per_km"`
+	TollCost      float64  `json:"toll_cost"`
+	AvoidUnpaved  *bool    `json:"avoid_unpaved"`
+	Blackouts     []string `json:"blackouts"`
+	AvoidAreas    []string `json:"avoid_areas"`
+}
+
+// Polygon is a list of [lon, lat]
+type Polygon [][]float64
+
+type Listener struct {
+	Listen          string   `json:"listen"`
+	Endpoints       []string `json:"endpoints"`
+	AllowedNetworks []string `json:"allowed_networks"`
+}
+
+type Address struct {
+	Query       string    `json:"query"`
+	Coordinates []float64 `json:"coordinates"`
+	Country     string    `json:"country"`
+	BoundingBox []float64 `json:"bounding_box"`
+	Index       int       `json:"index"`
+	Contains    string    `json:"contains"`
+	Geocoder    *Geocoder `json:"geocoder"`
 }
 
 type Config struct {
-	Addresses             map[string]string `json:"addresses"`
-	Paths                 []Path            `json:"paths"`
-	Listen                string            `json:"listen"`
-	Region                Region            `json:"region"`
-	Vehicle               Vehicle           `json:"vehicle"`
-	AvoidToll             bool              `json:"avoid_toll"`
-	AvoidSubscriptionRoad bool              `json:"avoid_subscription_road"`
-	AvoidFerry            bool              `json:"avoid_ferry"`
-	Sleep                 int64             `json:"sleep"`
+	Addresses             map[string]Address `json:"addresses"`
+	Paths                 []Path             `json:"paths"`
+	Listen                string             `json:"listen"`
+	Region                waze.Region        `json:"region"`
+	Vehicle               waze.Vehicle       `json:"vehicle"`
+	AvoidToll             bool               `json:"avoid_toll"`
+	AvoidSubscriptionRoad bool               `json:"avoid_subscription_road"`
+	AvoidFerry            bool               `json:"avoid_ferry"`
+	AvoidUnpaved          bool               `json:"avoid_unpaved"`
+	Routes                int                `json:"routes"`
+	ExtraOptions          map[string]string  `json:"extra_options"`
+	ExtraParams           map[string]string  `json:"extra_params"`
+	ExecHook              []string           `json:"exec_hook"`
+	ExecHookTimeout       int64              `json:"exec_hook_timeout"`
+	Outputs               []json.RawMessage  `json:"outputs"`
+	MaxIdleConns          int                `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int                `json:"max_idle_conns_per_host"`
+	IdleConnTimeout       int64              `json:"idle_conn_timeout"`
+	TLSHandshakeTimeout   int64              `json:"tls_handshake_timeout"`
+	HTTP2                 bool               `json:"http2"`
+	Sleep                 int64              `json:"sleep"`
+	Interval              int64              `json:"interval"`
+	Deadline              int64              `json:"deadline"`
+	Timeout               int64              `json:"timeout"`
+	ScrapeTimeoutOffset   float64            `json:"scrape_timeout_offset"`
+	QuantileWindow        int64              `json:"quantile_window"`
+	LastWeek              bool               `json:"last_week"`
+	Typical               bool               `json:"typical"`
+	BestDepartureWindow   int64              `json:"best_departure_window"`
+	BestDepartureStep     int64              `json:"best_departure_step"`
+	What3wordsKey         string             `json:"what3words_key"`
+	WaypointsFile         string             `json:"waypoints_file"`
+	MetricNames           map[string]string  `json:"metric_names"`
+	Language              string             `json:"language"`
+	NominatimFallback     bool               `json:"nominatim_fallback"`
+	NominatimURL          string             `json:"nominatim_url"`
+	NominatimEmail        string             `json:"nominatim_email"`
+	Geocoder              Geocoder           `json:"geocoder"`
+	GoogleAPIKey          string             `json:"google_api_key"`
+	LogFile               string             `json:"log_file"`
+	LogMaxSize            int64              `json:"log_max_size"`
+	LogMaxAge             int64              `json:"log_max_age"`
+	LogMaxBackups         int                `json:"log_max_backups"`
+	Syslog                string             `json:"syslog"`
+	SyslogFacility        string             `json:"syslog_facility"`
+	SyslogTag             string             `json:"syslog_tag"`
+	LeaderElection        string             `json:"leader_election"`
+	LeaderLockFile        string             `json:"leader_lock_file"`
+	LeaderKey             string             `json:"leader_key"`
+	LeaderTTL             int64              `json:"leader_ttl"`
+	RedisURL              string             `json:"redis_url"`
+	RedisCache            bool               `json:"redis_cache"`
+	RedisCachePrefix      string             `json:"redis_cache_prefix"`
+	RedisCacheTTL         int64              `json:"redis_cache_ttl"`
+	ShardIndex            int                `json:"shard_index"`
+	ShardCount            int                `json:"shard_count"`
+	LogLevel              string             `json:"log_level"`
+	AdminToken            string             `json:"admin_token"`
+	AllowedNetworks       []string           `json:"allowed_networks"`
+	Listeners             []Listener         `json:"listeners"`
+	Textfile              string             `json:"textfile"`
+	ReadyTimeout          int64              `json:"ready_timeout"`
+	HealthMaxAge          int64              `json:"health_max_age"`
+	NoRouteDown           bool               `json:"no_route_down"`
+	ThrottleMaxInterval   int64              `json:"throttle_max_interval"`
+	Shuffle               bool               `json:"shuffle"`
+	Pacing                bool               `json:"pacing"`
+	QuietHours            []string           `json:"quiet_hours"`
+	QuietInterval         int64              `json:"quiet_interval"`
+	Holidays              []string           `json:"holidays"`
+	Calendar              string             `json:"calendar"`
+	CalendarRefresh       int64              `json:"calendar_refresh"`
+	CO2PerKm              float64            `json:"co2_per_km"`
+	CostPerKm             float64            `json:"cost_per_km"`
+	CostPerMinute         float64            `json:"cost_per_minute"`
+	MaxPaths              int                `json:"max_paths"`
+	AvoidAreas            map[string]Polygon `json:"avoid_areas"`
+}
+
+// UnmarshalJSON accepts either a plain string or an object with selection rules
+func (a *Address) UnmarshalJSON(b []byte) error {
+	var query string
+	if err := json.Unmarshal(b, &query); err == nil {
+		*a = Address{Query: query}
+		return nil
+	}
+	type address Address
+	return json.Unmarshal(b, (*address)(a))
+}
+
+func (a Address) String() string {
+	if len(a.Coordinates) == 2 {
+		return fmt.Sprintf("%f,%f", a.Coordinates[0], a.Coordinates[1])
+	}
+	return a.Query
+}
+
+func (a Address) Filter() waze.AddressFilter {
+	return waze.AddressFilter{
+		Country:     a.Country,
+		BoundingBox: a.BoundingBox,
+		Index:       a.Index,
+		Contains:    a.Contains,
+	}
 }
 
 func NewConfig(filename string) *Config {
-	fd, err := os.Open(filename)
+	content, err := readConfigFile(filename)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer fd.Close()
 
 	config := &Config{
-		Listen: ":9091",
-		Sleep:  500,
+		Listen:              ":9091",
+		AvoidUnpaved:        true,
+		HTTP2:               true,
+		Sleep:               500,
+		Timeout:             10,
+		ScrapeTimeoutOffset: 1,
+		LogLevel:            "info",
+		ReadyTimeout:        300,
+		LogMaxSize:          10,
+		LogMaxBackups:       3,
+		SyslogFacility:      "daemon",
+		SyslogTag:           "prometheus-waze-exporter",
+		LeaderKey:           "prometheus-waze-exporter:leader",
+		LeaderTTL:           60,
+		RedisCachePrefix:    "prometheus-waze-exporter:route:",
+		RedisCacheTTL:       300,
+		CalendarRefresh:     3600,
+		MaxPaths:            1000,
+		ExecHookTimeout:     10,
+		BestDepartureStep:   900,
 	}
-	if err := json.NewDecoder(fd).Decode(config); err != nil {
+	if err := json.Unmarshal(stripJSONC(content), config); err != nil {
 		log.Fatalln(err)
 	}
+	if config.WaypointsFile != "" {
+		config.importWaypoints()
+	}
+	for name, address := range config.Addresses {
+		locations := 0
+		if address.Query != "" {
+			locations++
+		}
+		if address.Coordinates != nil {
+			locations++
+		}
+		if locations != 1 {
+			log.Fatalln("Address", name, "must have exactly one of query or coordinates")
+		}
+		if address.Coordinates != nil && len(address.Coordinates) != 2 {
+			log.Fatalln("The coordinates of address", name, "must be [lon, lat]")
+		}
+		if len(address.BoundingBox) != 0 && len(address.BoundingBox) != 4 {
+			log.Fatalln("The bounding box of address", name, "must be [min_lon, min_lat, max_lon, max_lat]")
+		}
+		if address.Index < 0 {
+			log.Fatalln("The index of address", name, "must not be negative")
+		}
+		if isWhat3words(address.Query) && config.What3wordsKey == "" {
+			log.Fatalln("what3words_key is required for address", name)
+		}
+		geocoder := config.Geocoder
+		if address.Geocoder != nil {
+			geocoder = *address.Geocoder
+		}
+		if geocoder == GoogleGeocoder && address.Query != "" && config.GoogleAPIKey == "" {
+			log.Fatalln("google_api_key is required for address", name)
+		}
+	}
+	if config.LeaderElection == "redis" && config.Interval >= config.LeaderTTL {
+		log.Fatalln("leader_ttl must be greater than interval")
+	}
+	if config.LeaderElection == "redis" && config.ThrottleMaxInterval >= config.LeaderTTL {
+		log.Fatalln("leader_ttl must be greater than throttle_max_interval")
+	}
+	if config.LeaderElection == "redis" && len(config.QuietHours) > 0 && config.QuietInterval >= config.LeaderTTL {
+		log.Fatalln("leader_ttl must be greater than quiet_interval")
+	}
+	if !config.Vehicle.Known() {
+		log.Println("Unknown vehicle", config.Vehicle, "sent as is to Waze")
+	}
+	metricNames := map[string]string{}
+	for name, override := range config.MetricNames {
+		if !model.IsValidMetricName(model.LabelValue(override)) {
+			log.Fatalln("Invalid metric name", override, "for", name)
+		}
+		if other, found := metricNames[override]; found {
+			log.Fatalln("Metrics", other, "and", name, "are both renamed", override)
+		}
+		metricNames[override] = name
+	}
+	if config.Pacing && config.Interval <= 0 {
+		log.Fatalln("pacing requires interval")
+	}
+	if len(config.QuietHours) > 0 {
+		if config.Interval <= 0 {
+			log.Fatalln("quiet_hours requires interval")
+		}
+		if config.QuietInterval <= config.Interval {
+			log.Fatalln("quiet_interval must be greater than interval")
+		}
+		for _, quietHours := range config.QuietHours {
+			if _, err := parseTimeRange(quietHours); err != nil {
+				log.Fatalln("Invalid quiet_hours:", err)
+			}
+		}
+	}
+	if len(config.ExecHook) > 0 && config.ExecHookTimeout <= 0 {
+		log.Fatalln("exec_hook_timeout must be positive")
+	}
+	if config.BestDepartureWindow > 0 && (config.BestDepartureStep < 60 || config.BestDepartureStep > config.BestDepartureWindow) {
+		log.Fatalln("best_departure_step must be between 60 and best_departure_window")
+	}
+	if config.Calendar != "" && config.CalendarRefresh <= 0 {
+		log.Fatalln("calendar_refresh must be positive")
+	}
+	if config.RedisCache && config.RedisCacheTTL <= 0 {
+		log.Fatalln("redis_cache_ttl must be positive")
+	}
+	for _, holiday := range config.Holidays {
+		if _, err := time.Parse(dateLayout, holiday); err != nil {
+			log.Fatalln("The holidays must be formatted as YYYY-MM-DD:", holiday)
+		}
+	}
+	if config.MaxPaths > 0 && len(config.Paths) > config.MaxPaths {
+		log.Fatalln("Too many paths:", len(config.Paths), "while max_paths is", config.MaxPaths)
+	}
+	for name, polygon := range config.AvoidAreas {
+		if len(polygon) < 3 {
+			log.Fatalln("The avoid area", name, "must have at least 3 points")
+		}
+		for _, coordinates := range polygon {
+			if len(coordinates) != 2 {
+				log.Fatalln("The points of the avoid area", name, "must be [lon, lat]")
+			}
+		}
+	}
+	for _, network := range config.AllowedNetworks {
+		if _, err := parseNetwork(network); err != nil {
+			log.Fatalln("Invalid allowed_networks:", err)
+		}
+	}
+	for _, listener := range config.Listeners {
+		if listener.Listen == "" {
+			log.Fatalln("The listeners must have a listen address")
+		}
+		for _, network := range listener.AllowedNetworks {
+			if _, err := parseNetwork(network); err != nil {
+				log.Fatalln("Invalid allowed_networks of listener", listener.Listen, err)
+			}
+		}
+	}
+	pathNames := map[string]bool{}
+	// the metrics are labelled with from and to, so 2 paths between the same
+	// addresses would export the same series
+	pathAddresses := map[[2]string]string{}
+	for i := range config.Paths {
+		path := &config.Paths[i]
+		if path.Name == "" {
+			path.Name = path.From + "_" + path.To
+		}
+		if pathNames[path.Name] {
+			log.Fatalln("Duplicate path name", path.Name)
+		}
+		if other, found := pathAddresses[[2]string{path.From, path.To}]; found {
+			log.Fatalln("Paths", other, "and", path.Name, "both go from", path.From, "to", path.To)
+		}
+		pathAddresses[[2]string{path.From, path.To}] = path.Name
+		if path.From == path.To {
+			log.Fatalln("Path", path.Name, "must go from an address to another one, not from", path.From, "to itself")
+		}
+		if _, found := config.Addresses[path.From]; !found {
+			log.Fatalln("Path", path.Name, "goes from an unknown address:", path.From)
+		}
+		if _, found := config.Addresses[path.To]; !found {
+			log.Fatalln("Path", path.Name, "goes to an unknown address:", path.To)
+		}
+		for _, blackout := range path.Blackouts {
+			if _, err := parseTimeRange(blackout); err != nil {
+				log.Fatalln("Path", path.Name, "has an invalid blackout:", err)
+			}
+		}
+		for _, area := range path.AvoidAreas {
+			if _, found := config.AvoidAreas[area]; !found {
+				log.Fatalln("Path", path.Name, "has an unknown avoid area:", area)
+			}
+		}
+		if path.CalendarEvent != "" {
+			if config.Calendar == "" {
+				log.Fatalln("Path", path.Name, "has a calendar_event, but calendar is not set")
+			}
+			if _, err := regexp.Compile(path.CalendarEvent); err != nil {
+				log.Fatalln("Path", path.Name, "has an invalid calendar_event:", err)
+			}
+		}
+		pathNames[path.Name] = true
+	}
+	if config.ShardCount > 1 || config.ShardIndex != 0 {
+		if config.ShardIndex < 0 || config.ShardIndex >= config.ShardCount {
+			log.Fatalln("shard_index must be between 0 and shard_count - 1")
+		}
+		config.shard()
+	}
 
 	return config
 }
+
+// readConfigFile returns the content of the configuration file. The Jsonnet
+// and CUE files are evaluated by their command line tool, which must be in the
+// PATH
+func readConfigFile(filename string) ([]byte, error) {
+	if filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	var cmd *exec.Cmd
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonnet":
+		cmd = exec.Command("jsonnet", filename)
+	case ".cue":
+		cmd = exec.Command("cue", "export", "--out", "json", filename)
+	default:
+		return os.ReadFile(filename)
+	}
+	log.Println("Evaluate", filename, "with", cmd.Path)
+	cmd.Stderr = os.Stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not evaluate %s: %w", filename, err)
+	}
+	return content, nil
+}
+
+// shard keeps only the paths of this shard and the addresses they use. The
+// paths are split depending on a hash of their name, so all the instances
+// agree whatever the order of the paths
+func (c *Config) shard() {
+	paths := []Path{}
+	addresses := map[string]Address{}
+	for _, path := range c.Paths {
+		hash := fnv.New32a()
+		hash.Write([]byte(path.Name))
+		if int(hash.Sum32()%uint32(c.ShardCount)) == c.ShardIndex {
+			paths = append(paths, path)
+			addresses[path.From] = c.Addresses[path.From]
+			addresses[path.To] = c.Addresses[path.To]
+		}
+	}
+	log.Println("Shard", c.ShardIndex, "of", c.ShardCount, "monitors", len(paths), "paths out of", len(c.Paths))
+	c.Paths = paths
+	c.Addresses = addresses
+}
+
+// importWaypoints adds the waypoints of WaypointsFile to the addresses and
+// monitors the path between each consecutive waypoints
+func (c *Config) importWaypoints() {
+	waypoints, err := loadWaypoints(c.WaypointsFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Import", len(waypoints), "waypoints from", c.WaypointsFile)
+
+	if c.Addresses == nil {
+		c.Addresses = map[string]Address{}
+	}
+	prefix := strings.TrimSuffix(filepath.Base(c.WaypointsFile), filepath.Ext(c.WaypointsFile))
+	previous := ""
+	for i, point := range waypoints {
+		name := point.Name
+		if name == "" {
+			name = prefix + "_" + strconv.Itoa(i)
+		}
+		// a round may come back to a waypoint already seen
+		if address, found := c.Addresses[name]; !found {
+			c.Addresses[name] = Address{Coordinates: []float64{point.Lon, point.Lat}}
+		} else if len(address.Coordinates) != 2 || address.Coordinates[0] != point.Lon || address.Coordinates[1] != point.Lat {
+			log.Fatalln("Waypoint", name, "from", c.WaypointsFile, "is already defined with other coordinates")
+		}
+		if previous != "" {
+			c.Paths = append(c.Paths, Path{From: previous, To: name})
+		}
+		previous = name
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardHistory is the maximum duration of the history sent to the
+// dashboard
+const dashboardHistory = 24 * time.Hour
+
+type dashboardStatus struct {
+	Paths []dashboardPath `json:"paths"`
+}
+
+type dashboardPath struct {
+	Name              string       `json:"name"`
+	From              string       `json:"from"`
+	To                string       `json:"to"`
+	LastUpdate        *time.Time   `json:"last_update,omitempty"`
+	TravelTimeSeconds *float64     `json:"travel_time_seconds,omitempty"`
+	DistanceMeters    *int         `json:"distance_meters,omitempty"`
+	History           [][2]float64 `json:"history,omitempty"`
+}
+
+// dashboardHandler serves a self-contained page drawing the travel times
+func dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+}
+
+// dashboardStatusHandler serves the last values of the paths for the
+// dashboard. Unlike /metrics, it never calls Waze API
+func (c *context) dashboardStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := dashboardStatus{Paths: []dashboardPath{}}
+		now := time.Now()
+		for _, metric := range c.wazeMetrics {
+			path := dashboardPath{
+				Name: metric.name,
+				From: metric.from,
+				To:   metric.to,
+			}
+			if lastUpdate := metric.getLastUpdate(); !lastUpdate.IsZero() {
+				path.LastUpdate = &lastUpdate
+			}
+			if results, ok := metric.lastResults.Load().([]waze.Result); ok {
+				result := results[0]
+				travelTime := result.Duration.Seconds()
+				path.TravelTimeSeconds = &travelTime
+				path.DistanceMeters = &result.Distance
+			}
+			if metric.history != nil {
+				for _, sample := range metric.history.since(now.Add(-dashboardHistory)) {
+					path.History = append(path.History, [2]float64{float64(sample.Time.Unix()), sample.Value})
+				}
+			}
+			status.Paths = append(status.Paths, path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+// geocoders turns the configured addresses into Waze coordinates
+type geocoders struct {
+	region            waze.Region
+	language          string
+	defaultGeocoder   Geocoder
+	nominatimFallback bool
+	what3wordsKey     string
+	nominatim         *Nominatim
+	google            *Google
+	client            *http.Client
+	wazeClient        *waze.Client
+	requests          *prometheus.CounterVec
+	duration          *prometheus.HistogramVec
+	wazeCalls         *prometheus.CounterVec
+	wazeTimeSpent     *prometheus.CounterVec
+}
+
+func newGeocoders(jsonConfig *Config, client *http.Client) *geocoders {
+	return &geocoders{
+		region:            jsonConfig.Region,
+		language:          jsonConfig.Language,
+		defaultGeocoder:   jsonConfig.Geocoder,
+		nominatimFallback: jsonConfig.NominatimFallback,
+		what3wordsKey:     jsonConfig.What3wordsKey,
+		nominatim:         NewNominatim(jsonConfig.NominatimURL, jsonConfig.NominatimEmail, jsonConfig.Language, client),
+		google:            NewGoogle(jsonConfig.GoogleAPIKey, jsonConfig.Language, client),
+		client:            client,
+		wazeClient:        &waze.Client{HTTP: client, Log: log.Println, Debug: logDebug},
+		requests:          promWazeGeocodingRequests,
+		duration:          promWazeGeocodingDuration,
+		wazeCalls:         promWazeCalls,
+		wazeTimeSpent:     promWazeTimeSpent,
+	}
+}
+
+func (g *geocoders) resolve(address Address) (string, error) {
+	switch {
+	case len(address.Coordinates) == 2:
+		return waze.CoordinatesToQuery(address.Coordinates[0], address.Coordinates[1]), nil
+	case isPlusCode(address.Query):
+		lat, lon, err := decodePlusCode(address.Query)
+		return waze.CoordinatesToQuery(lon, lat), err
+	case isWhat3words(address.Query):
+		return g.observe("what3words", func() (string, error) {
+			return What3wordsToQuery(address.Query, g.what3wordsKey, g.client)
+		})
+	}
+
+	geocoder := g.defaultGeocoder
+	if address.Geocoder != nil {
+		geocoder = *address.Geocoder
+	}
+	switch geocoder {
+	case GoogleGeocoder:
+		return g.observe("google", func() (string, error) {
+			return g.google.AddressToQuery(address.Query, address.Filter())
+		})
+	case NominatimGeocoder:
+		return g.observe("nominatim", func() (string, error) {
+			return g.nominatim.AddressToQuery(address.Query, address.Filter())
+		})
+	}
+
+	coordinates, err := g.observe("waze", func() (string, error) {
+		return waze.AddressToQuery(address.Query, address.Filter(), g.language, g.region, g.wazeClient)
+	})
+	if err != nil && g.nominatimFallback {
+		log.Println("Waze failed to retrieve the address", address.Query, err, "fallback to Nominatim")
+		return g.observe("nominatim", func() (string, error) {
+			return g.nominatim.AddressToQuery(address.Query, address.Filter())
+		})
+	}
+	return coordinates, err
+}
+
+// observe records the status and the duration of a geocoding request
+func (g *geocoders) observe(geocoder string, request func() (string, error)) (string, error) {
+	begin := time.Now()
+	coordinates, err := request()
+	duration := time.Since(begin)
+	g.duration.WithLabelValues(geocoder).Observe(duration.Seconds())
+	if geocoder == "waze" {
+		// not finding an address is a valid answer of Waze API
+		wazeStatus := "ok"
+		if err != nil && !errors.Is(err, waze.ErrAddressNotFound) {
+			wazeStatus = "ko"
+		}
+		g.wazeCalls.WithLabelValues("geocoding", g.region.String(), wazeStatus).Inc()
+		g.wazeTimeSpent.WithLabelValues("geocoding", g.region.String()).Add(duration.Seconds())
+	}
+
+	status := "success"
+	if errors.Is(err, waze.ErrAddressNotFound) {
+		status = "not_found"
+	} else if err != nil {
+		status = "error"
+	}
+	g.requests.WithLabelValues(geocoder, status).Inc()
+	return coordinates, err
+}
+
+// regionBoundingBoxes are rough [min_lon, min_lat, max_lon, max_lat] boxes of
+// the areas served by the regional Waze servers
+var regionBoundingBoxes = map[waze.Region][][]float64{
+	waze.US: {
+		{-125, 24, -66, 50},    // contiguous United States
+		{-180, 51, -129, 72},   // Alaska
+		{-161, 18, -154, 23},   // Hawaii
+		{-68, 17.5, -64.5, 19}, // Puerto Rico and Virgin Islands
+	},
+	waze.IL: {
+		{34.2, 29.4, 35.95, 33.4},
+	},
+}
+
+func inBoundingBoxes(lon float64, lat float64, boxes [][]float64) bool {
+	for _, box := range boxes {
+		if lon >= box[0] && lat >= box[1] && lon <= box[2] && lat <= box[3] {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCoordinates checks that the coordinates are plausible and served by
+// the region
+func validateCoordinates(coordinates string, region waze.Region) error {
+	var lon, lat float64
+	if _, err := fmt.Sscanf(coordinates, "x:%f y:%f", &lon, &lat); err != nil {
+		return fmt.Errorf("Invalid coordinates %s: %s", coordinates, err)
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return fmt.Errorf("Coordinates %s are out of range, check that longitude and latitude are not swapped", coordinates)
+	}
+	if lat == 0 && lon == 0 {
+		return fmt.Errorf("Coordinates %s are in the middle of the ocean, the address has probably not been found", coordinates)
+	}
+
+	if boxes, found := regionBoundingBoxes[region]; found {
+		if !inBoundingBoxes(lon, lat, boxes) {
+			return fmt.Errorf("Coordinates %s are outside of region %s, check the region or the address", coordinates, region)
+		}
+		return nil
+	}
+	for otherRegion, boxes := range regionBoundingBoxes {
+		if inBoundingBoxes(lon, lat, boxes) {
+			log.Println("Warning: coordinates", coordinates, "seem to be in region", otherRegion, "but region", region, "is configured")
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Geocoder
+////////////////////////////////////////////////////////////////////////////////
+
+type Geocoder int
+
+const (
+	WazeGeocoder Geocoder = iota
+	GoogleGeocoder
+	NominatimGeocoder
+)
+
+var marshalGeocoderMap = map[Geocoder]string{
+	WazeGeocoder:      "WAZE",
+	GoogleGeocoder:    "GOOGLE",
+	NominatimGeocoder: "NOMINATIM",
+}
+
+var unmarshalGeocoderMap = map[string]Geocoder{
+	"":          WazeGeocoder,
+	"WAZE":      WazeGeocoder,
+	"GOOGLE":    GoogleGeocoder,
+	"NOMINATIM": NominatimGeocoder,
+}
+
+func (s Geocoder) String() string {
+	return marshalGeocoderMap[s]
+}
+
+func (s Geocoder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Geocoder) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	if val, found := unmarshalGeocoderMap[strings.ToUpper(j)]; found {
+		*s = val
+		return nil
+	}
+	return errors.New("Cannot unmarshal " + j + " as geocoder")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+const (
+	googleGeocodingURL = "https://maps.googleapis.com/maps/api/geocode/json"
+)
+
+// Google is the Google Geocoding API, see
+// https://developers.google.com/maps/documentation/geocoding/requests-geocoding
+type Google struct {
+	client   *http.Client
+	key      string
+	language string
+}
+
+func NewGoogle(key string, language string, client *http.Client) *Google {
+	return &Google{
+		client:   client,
+		key:      key,
+		language: language,
+	}
+}
+
+func (g *Google) AddressToQuery(address string, filter waze.AddressFilter) (string, error) {
+	param := url.Values{}
+	param.Set("address", address)
+	if g.language != "" {
+		param.Set("language", g.language)
+	}
+	if len(filter.BoundingBox) == 4 {
+		// bounds is south-west|north-east as lat,lng
+		param.Set("bounds", fmt.Sprintf("%f,%f|%f,%f", filter.BoundingBox[1], filter.BoundingBox[0], filter.BoundingBox[3], filter.BoundingBox[2]))
+	}
+	logDebug("Call", googleGeocodingURL+"?"+param.Encode())
+	param.Set("key", g.key)
+
+	resp, err := g.client.Get(googleGeocodingURL + "?" + param.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("Got HTTP %d %s", resp.StatusCode, resp.Status)
+	}
+
+	decodedResponse := googleResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decodedResponse); err != nil {
+		return "", err
+	}
+	switch decodedResponse.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return "", fmt.Errorf("%w: %s", waze.ErrAddressNotFound, address)
+	default:
+		return "", fmt.Errorf("Google Geocoding error %s: %s", decodedResponse.Status, decodedResponse.ErrorMessage)
+	}
+
+	index := filter.Index
+	for i := range decodedResponse.Results {
+		result := &decodedResponse.Results[i]
+		if !result.match(filter) {
+			logDebug("Skip candidate", result.FormattedAddress)
+			continue
+		}
+		if index > 0 {
+			logDebug("Skip candidate", result.FormattedAddress)
+			index--
+			continue
+		}
+		log.Println("Select candidate", result.FormattedAddress, result.Geometry.Location.Lng, result.Geometry.Location.Lat)
+		return waze.CoordinatesToQuery(result.Geometry.Location.Lng, result.Geometry.Location.Lat), nil
+	}
+	return "", fmt.Errorf("%w, no candidate out of %d matches the selection rules: %s", waze.ErrAddressNotFound, len(decodedResponse.Results), address)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// googleResponse
+////////////////////////////////////////////////////////////////////////////////
+
+type googleResponse struct {
+	Status       string         `json:"status"`
+	ErrorMessage string         `json:"error_message"`
+	Results      []googleResult `json:"results"`
+}
+
+type googleResult struct {
+	FormattedAddress  string                   `json:"formatted_address"`
+	AddressComponents []googleAddressComponent `json:"address_components"`
+	Geometry          googleGeometry           `json:"geometry"`
+}
+
+type googleAddressComponent struct {
+	LongName  string   `json:"long_name"`
+	ShortName string   `json:"short_name"`
+	Types     []string `json:"types"`
+}
+
+// match applies the selection rules which Google does not support. The
+// country may be its name or its ISO 3166-1 code
+func (g *googleResult) match(filter waze.AddressFilter) bool {
+	if filter.Country != "" {
+		found := false
+		for _, component := range g.AddressComponents {
+			for _, componentType := range component.Types {
+				if componentType == "country" && (strings.EqualFold(filter.Country, component.LongName) || strings.EqualFold(filter.Country, component.ShortName)) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Contains != "" && !strings.Contains(strings.ToLower(g.FormattedAddress), strings.ToLower(filter.Contains)) {
+		return false
+	}
+	return true
+}
+
+type googleGeometry struct {
+	Location googleLocation `json:"location"`
+}
+
+type googleLocation struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type historySample struct {
+	Time  time.Time
+	Value float64
+}
+
+type history struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	samples []historySample
+}
+
+func newHistory(window time.Duration) *history {
+	return &history{
+		window: window,
+	}
+}
+
+func (h *history) add(t time.Time, value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.samples = append(h.samples, historySample{Time: t, Value: value})
+	h.prune(t)
+}
+
+// prune removes the samples older than the window. The mutex must be held
+func (h *history) prune(now time.Time) {
+	limit := now.Add(-h.window)
+	i := 0
+	for i < len(h.samples) && h.samples[i].Time.Before(limit) {
+		i++
+	}
+	if i > 0 {
+		h.samples = append(h.samples[:0], h.samples[i:]...)
+	}
+}
+
+// restore replaces the samples, for instance with the ones of a snapshot
+func (h *history) restore(samples []historySample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.samples = append([]historySample(nil), samples...)
+	h.prune(time.Now())
+}
+
+// quantile returns the q-quantile (0 <= q <= 1) of the values recorded since
+// the given time, or NaN if there is none
+func (h *history) quantile(since time.Time, q float64) float64 {
+	h.mutex.Lock()
+	values := []float64{}
+	for _, sample := range h.samples {
+		if !sample.Time.Before(since) {
+			values = append(values, sample.Value)
+		}
+	}
+	h.mutex.Unlock()
+
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	rank := q * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// since returns a copy of the samples recorded since the given time
+func (h *history) since(t time.Time) []historySample {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	result := []historySample{}
+	for _, sample := range h.samples {
+		if !sample.Time.Before(t) {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+// at returns the value recorded the closest to the given time, provided it is
+// within the tolerance
+func (h *history) at(t time.Time, tolerance time.Duration) (float64, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	found := false
+	best := time.Duration(0)
+	value := 0.
+	for _, sample := range h.samples {
+		delta := sample.Time.Sub(t)
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta <= tolerance && (!found || delta < best) {
+			found = true
+			best = delta
+			value = sample.Value
+		}
+	}
+	return value, found
+}
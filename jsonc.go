@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+)
+
+// stripJSONC turns JSON with comments (// and /* */) and trailing commas into
+// plain JSON. They are replaced by spaces so the offsets in the errors are
+// kept
+func stripJSONC(content []byte) []byte {
+	result := make([]byte, len(content))
+	copy(result, content)
+
+	inString := false
+	comma := -1 // offset of the last comma, which may be trailing
+	for i := 0; i < len(result); i++ {
+		c := result[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '/' && i+1 < len(result) && result[i+1] == '/':
+			for ; i < len(result) && result[i] != '\n'; i++ {
+				result[i] = ' '
+			}
+			continue
+		case c == '/' && i+1 < len(result) && result[i+1] == '*':
+			end := bytes.Index(result[i+2:], []byte("*/"))
+			if end < 0 {
+				// unterminated, kept so that the JSON is invalid
+				return result
+			}
+			for end += i + 4; i < end; i++ {
+				if result[i] != '\n' {
+					result[i] = ' '
+				}
+			}
+			i--
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			continue
+		case c == ',':
+			comma = i
+			continue
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				result[comma] = ' '
+			}
+		case c == '"':
+			inString = true
+		}
+		comma = -1
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "plain",
+			content:  `{"a": [1, 2], "b": {}}`,
+			expected: `{"a": [1, 2], "b": {}}`,
+		},
+		{
+			name:     "line comment",
+			content:  "{\"a\": 1 // one\n}",
+			expected: "{\"a\": 1       \n}",
+		},
+		{
+			name:     "block comment",
+			content:  "{/* one\ntwo */\"a\": 1}",
+			expected: "{      \n      \"a\": 1}",
+		},
+		{
+			name:     "comments in strings",
+			content:  `{"url": "http://host/*path*/", "b": "/* c */"}`,
+			expected: `{"url": "http://host/*path*/", "b": "/* c */"}`,
+		},
+		{
+			name:     "escaped quotes",
+			content:  `{"a": "x\"//y\\", "b": "\\"} // c`,
+			expected: `{"a": "x\"//y\\", "b": "\\"}     `,
+		},
+		{
+			name:     "trailing commas",
+			content:  `{"a": [1, 2,], "b": {"c": 3,},}`,
+			expected: `{"a": [1, 2 ], "b": {"c": 3 } }`,
+		},
+		{
+			name:     "trailing comma before a comment",
+			content:  "{\"a\": [1, /* two */\n], \"b\": 2, // three\n}",
+			expected: "{\"a\": [1           \n], \"b\": 2          \n}",
+		},
+		{
+			name:     "comma in a string",
+			content:  `["a,", "b"]`,
+			expected: `["a,", "b"]`,
+		},
+		{
+			name:     "unterminated block comment",
+			content:  `{"a": 1, /* b}`,
+			expected: `{"a": 1, /* b}`,
+		},
+		{
+			name:     "unterminated string",
+			content:  `{"a": "b // c}`,
+			expected: `{"a": "b // c}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(stripJSONC([]byte(test.content))); got != test.expected {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
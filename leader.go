@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// leaderElector decides which replica calls Waze API when several of them are
+// running for redundancy
+type leaderElector interface {
+	// campaign tries to become or to stay the leader. It returns true if this
+	// replica is the leader
+	campaign() bool
+}
+
+func newLeaderElector(config *Config) (leaderElector, error) {
+	switch config.LeaderElection {
+	case "":
+		return nil, nil
+	case "file":
+		return newFileLeaderElector(config.LeaderLockFile)
+	case "redis":
+		client, err := newRedisClient(config.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return newRedisLeaderElector(client, config.LeaderKey, time.Second*time.Duration(config.LeaderTTL)), nil
+	}
+	return nil, errors.New("Unknown leader election backend: " + config.LeaderElection)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// redisLeaderElector
+////////////////////////////////////////////////////////////////////////////////
+
+// redisLeaderElector holds a key with a TTL. The leader keeps extending it
+type redisLeaderElector struct {
+	client *redisClient
+	key    string
+	id     string
+	ttl    time.Duration
+}
+
+const (
+	// extends the TTL of the key only if this replica holds it
+	redisRenewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+func newRedisLeaderElector(client *redisClient, key string, ttl time.Duration) *redisLeaderElector {
+	hostname, _ := os.Hostname()
+	return &redisLeaderElector{
+		client: client,
+		key:    key,
+		id:     hostname + "-" + strconv.Itoa(os.Getpid()),
+		ttl:    ttl,
+	}
+}
+
+func (r *redisLeaderElector) campaign() bool {
+	ttl := strconv.FormatInt(r.ttl.Milliseconds(), 10)
+	reply, err := r.client.Do("SET", r.key, r.id, "NX", "PX", ttl)
+	if err != nil {
+		log.Println("Leader election failed", err)
+		return false
+	}
+	if reply == "OK" {
+		return true
+	}
+	reply, err = r.client.Do("EVAL", redisRenewScript, "1", r.key, r.id, ttl)
+	if err != nil {
+		log.Println("Leader election failed", err)
+		return false
+	}
+	return reply == int64(1)
+}
@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLeaderElector holds an exclusive lock on a file shared by the replicas.
+// The lock is released when the process exits
+type fileLeaderElector struct {
+	file   *os.File
+	leader bool
+}
+
+func newFileLeaderElector(filename string) (leaderElector, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLeaderElector{
+		file: file,
+	}, nil
+}
+
+func (f *fileLeaderElector) campaign() bool {
+	if !f.leader {
+		f.leader = syscall.Flock(int(f.file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil
+	}
+	return f.leader
+}
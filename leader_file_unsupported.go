@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+)
+
+func newFileLeaderElector(filename string) (leaderElector, error) {
+	return nil, errors.New("file leader election is not supported on this platform")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// listener is an address on which the exporter serves some of its endpoints
+type listener struct {
+	listen          string
+	endpoints       []string // all the endpoints if empty
+	allowedNetworks []*net.IPNet
+}
+
+// handler returns the handler serving the enabled endpoints, among all the
+// endpoints of the exporter
+func (l *listener) handler(endpoints map[string]http.Handler) (http.Handler, error) {
+	mux := http.NewServeMux()
+	if len(l.endpoints) == 0 {
+		for pattern, handler := range endpoints {
+			mux.Handle(pattern, handler)
+		}
+	}
+	for _, pattern := range l.endpoints {
+		handler, found := endpoints[pattern]
+		if !found {
+			return nil, fmt.Errorf("Unknown endpoint %q on %s", pattern, l.listen)
+		}
+		mux.Handle(pattern, handler)
+	}
+	if len(l.allowedNetworks) > 0 {
+		return allowlistHandler(l.allowedNetworks, mux), nil
+	}
+	return mux, nil
+}
+
+func (l *listener) serves(pattern string) bool {
+	if len(l.endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range l.endpoints {
+		if endpoint == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// serve listens on all the listeners with their handler, notifies systemd
+// that the exporter is ready, and returns when one of them fails
+func serve(listeners []listener, handlers []http.Handler) error {
+	netListeners := []net.Listener{}
+	for _, listener := range listeners {
+		netListener, err := net.Listen("tcp", listener.listen)
+		if err != nil {
+			return err
+		}
+		log.Println("Listen on", listener.listen)
+		netListeners = append(netListeners, netListener)
+	}
+	sdNotify("READY=1")
+
+	errors := make(chan error, len(listeners))
+	for i := range netListeners {
+		netListener, handler := netListeners[i], handlers[i]
+		go func() {
+			errors <- http.Serve(netListener, handler)
+		}()
+	}
+	return <-errors
+}
+
+// newListeners returns the listeners, or the single listen address if they
+// are not configured. The networks are already checked with the configuration
+func newListeners(jsonConfig *Config) []listener {
+	parseNetworks := func(values []string) []*net.IPNet {
+		result := []*net.IPNet{}
+		for _, value := range values {
+			network, _ := parseNetwork(value)
+			result = append(result, network)
+		}
+		return result
+	}
+	if len(jsonConfig.Listeners) == 0 {
+		return []listener{{
+			listen:          jsonConfig.Listen,
+			allowedNetworks: parseNetworks(jsonConfig.AllowedNetworks),
+		}}
+	}
+	result := []listener{}
+	for _, config := range jsonConfig.Listeners {
+		allowedNetworks := config.AllowedNetworks
+		if allowedNetworks == nil {
+			allowedNetworks = jsonConfig.AllowedNetworks
+		}
+		result = append(result, listener{
+			listen:          config.Listen,
+			endpoints:       config.Endpoints,
+			allowedNetworks: parseNetworks(allowedNetworks),
+		})
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file which is rotated when it is too big or too old.
+// The rotated files are named <filename>.1 (the most recent) to
+// <filename>.<maxBackups>
+type rotatingFile struct {
+	mutex      sync.Mutex
+	filename   string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+func newRotatingFile(filename string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the log file in append mode. The mutex must be held
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.opened = info.ModTime()
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+	return nil
+}
+
+// rotate shifts the backups and reopens an empty log file. The mutex must be
+// held
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	if r.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.filename, r.maxBackups))
+		for i := r.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.filename, i), fmt.Sprintf("%s.%d", r.filename, i+1))
+		}
+		os.Rename(r.filename, r.filename+".1")
+	} else {
+		os.Remove(r.filename)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tooBig := r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0
+	tooOld := r.maxAge > 0 && time.Since(r.opened) > r.maxAge && r.size > 0
+	if tooBig || tooOld {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
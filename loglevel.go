@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	logLevelInfo int32 = iota
+	logLevelDebug
+)
+
+var (
+	logLevel      = logLevelInfo
+	logLevelNames = map[int32]string{
+		logLevelInfo:  "info",
+		logLevelDebug: "debug",
+	}
+)
+
+func setLogLevel(name string) error {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			atomic.StoreInt32(&logLevel, level)
+			return nil
+		}
+	}
+	return errors.New("Unknown log level: " + name)
+}
+
+func getLogLevel() string {
+	return logLevelNames[atomic.LoadInt32(&logLevel)]
+}
+
+// logDebug logs only if the debug level is enabled
+func logDebug(v ...interface{}) {
+	if atomic.LoadInt32(&logLevel) >= logLevelDebug {
+		log.Println(v...)
+	}
+}
+
+// requireToken rejects the requests which do not have the header
+// "Authorization: Bearer <token>"
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(authorization, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logLevelHandler returns the log level on GET and changes it on PUT
+func logLevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := setLogLevel(string(body)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Println("Log level set to", getLogLevel(), "by", r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		io.WriteString(w, getLogLevel()+"\n")
+	})
+}
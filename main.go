@@ -1,141 +1,928 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"math/rand"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
 )
 
 type wazeMetric struct {
-	wazeParameters     WazeParameters
-	wazeRequest        *WazeRequest
+	name               string
+	from               string
+	to                 string
+	wazeParameters     waze.Parameters
+	wazeRequest        *waze.Request
+	requestKey         string // identical for the paths requesting the same route
 	timeTravelTime     prometheus.Gauge
 	timeTravelDistance prometheus.Gauge
+	estimatedArrival   prometheus.Gauge
+	closures           prometheus.Gauge
+	pathInfo           prometheus.Gauge
+	history            *history
+	quantileWindow     time.Duration
+	timeTravelQuantile []prometheus.Gauge
+	timeTravelLastWeek prometheus.Gauge
+	typicalRequest     *waze.Request
+	timeTravelTypical  prometheus.Gauge
+	timeTravelRoutes   []prometheus.Gauge // min, max and mean
+	departureRequests  []*waze.Request    // one per departureOffsets
+	departureOffsets   []time.Duration
+	bestDeparture      prometheus.Gauge
+	avoidAreas         []*avoidArea
+	co2Emissions       prometheus.Gauge
+	co2PerKm           float64
+	tripCost           prometheus.Gauge
+	costPerKm          float64
+	costPerMinute      float64
+	tollCost           float64
+	cache              *resultCache
+	noRouteDown        bool
+	schedule           *schedule
+	lastUpdate         int64        // unix time in nanoseconds, atomic
+	lastResults        atomic.Value // []waze.Result, the last routes
+	lastTypical        atomic.Value // float64, the last typical travel time in seconds
+	outputs            outputs
 }
 
 type context struct {
-	sleepTime      time.Duration
-	listen         string
-	wazeMetrics    []*wazeMetric
-	wazeTimeSpent  prometheus.Counter
-	wazeCallsOk    prometheus.Counter
-	wazeCallsKo    prometheus.Counter
-	wazeParameters prometheus.Counter
+	sleepTime           time.Duration
+	interval            time.Duration
+	listeners           []listener
+	textfile            string
+	addresses           map[string]*stateAddress
+	adminToken          string
+	startTime           time.Time
+	readyTimeout        time.Duration
+	healthMaxAge        time.Duration
+	deadline            time.Duration
+	scrapeTimeoutOffset time.Duration
+	wazeTimeouts        prometheus.Counter
+	geocodingRequests   *prometheus.CounterVec
+	geocodingDuration   *prometheus.HistogramVec
+	schemaWarnings      *prometheus.CounterVec
+	wazeMetrics         []*wazeMetric
+	wazeTime            *prometheus.CounterVec
+	wazeTimeSpent       prometheus.Counter
+	wazeCalls           *prometheus.CounterVec
+	wazeCallsOk         prometheus.Counter
+	wazeCallsKo         prometheus.Counter
+	wazeCallsNoRoute    prometheus.Counter
+	throttle            *throttle
+	shuffle             bool
+	pacing              bool
+	quietHours          []timeRange
+	quietInterval       time.Duration
+	configuredPaths     prometheus.Gauge
+	wazeParameters      prometheus.Gauge
+	leader              leaderElector
+	wazeLeader          prometheus.Gauge
+	cache               *resultCache
+	wazeCacheHits       prometheus.Counter
+	wazeCoalesced       prometheus.Counter
 }
 
 const (
 	namespace = "waze"
 )
 
+const (
+	week              = 7 * 24 * time.Hour
+	lastWeekTolerance = 30 * time.Minute
+)
+
 var (
-	promWazeTravelTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "travel_time_seconds",
-		Help:      "travel time in seconds",
-	}, []string{"from", "to"})
-	promWazeTravelDistance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "travel_distance_meters",
-		Help:      "travel distance in meters",
-	}, []string{"from", "to"})
-	promWazeCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "api_calls",
-		Help:      "number of calls to the Waze API",
-	}, []string{"status"})
-	promWazeParams = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "parameters",
-		Help:      "Waze parameters",
-	}, []string{"region", "sleep", "vehicle", "avoid_toll", "avoid_subscription_road", "avoid_ferry"})
-	promWazeTimeSpent = prometheus.NewCounter(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "time_seconds",
-		Help:      "total time spent to to process Waze API",
-	})
+	travelTimeQuantiles = []float64{0.5, 0.95}
+)
+
+var (
+	promWazeTravelTime         *prometheus.GaugeVec
+	promWazeTravelDistance     *prometheus.GaugeVec
+	promWazeEstimatedArrival   *prometheus.GaugeVec
+	promWazeRouteClosures      *prometheus.GaugeVec
+	promWazeCO2Emissions       *prometheus.GaugeVec
+	promWazeTripCost           *prometheus.GaugeVec
+	promWazeTravelTimeQuantile *prometheus.GaugeVec
+	promWazeTravelTimeLastWeek *prometheus.GaugeVec
+	promWazeTravelTimeTypical  *prometheus.GaugeVec
+	promWazeTravelTimeRoutes   *prometheus.GaugeVec
+	promWazeBestDeparture      *prometheus.GaugeVec
+	promWazeAvoidAreaViolation *prometheus.GaugeVec
+	promWazePathInfo           *prometheus.GaugeVec
+	promWazeCalls              *prometheus.CounterVec
+	promWazeParams             *prometheus.GaugeVec
+	promWazeTimeSpent          *prometheus.CounterVec
+	promWazeLeader             prometheus.Gauge
+	promWazeCacheHits          prometheus.Counter
+	promWazeCoalescedCalls     prometheus.Counter
+	promWazeTimeouts           prometheus.Counter
+	promWazeGeocodingRequests  *prometheus.CounterVec
+	promWazeSchemaWarnings     *prometheus.CounterVec
+	promWazeInterval           prometheus.Gauge
+	promWazeConfiguredPaths    prometheus.Gauge
+	promWazeGeocodingDuration  *prometheus.HistogramVec
+
+	// travelTimeName is the full name of waze_travel_time_seconds, which may
+	// be overridden
+	travelTimeName string
 )
 
+// initMetrics creates the metrics. names allows to override the full name of
+// some metrics, for instance {"waze_travel_time_seconds": "commute_seconds"}
+func initMetrics(names map[string]string) {
+	used := map[string]bool{}
+	opts := func(name string, help string) prometheus.Opts {
+		fqName := prometheus.BuildFQName(namespace, "", name)
+		if override, found := names[fqName]; found {
+			used[fqName] = true
+			return prometheus.Opts{Name: override, Help: help}
+		}
+		return prometheus.Opts{Namespace: namespace, Name: name, Help: help}
+	}
+
+	travelTimeOpts := opts("travel_time_seconds", "travel time in seconds")
+	travelTimeName = prometheus.BuildFQName(travelTimeOpts.Namespace, "", travelTimeOpts.Name)
+	promWazeTravelTime = prometheus.NewGaugeVec(prometheus.GaugeOpts(travelTimeOpts), []string{"from", "to"})
+	promWazeTravelDistance = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("travel_distance_meters", "travel distance in meters")), []string{"from", "to"})
+	promWazeCO2Emissions = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("co2_emissions_grams", "estimated CO2 emissions of the trip in grams")), []string{"from", "to"})
+	promWazeTripCost = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("trip_cost_estimate", "estimated cost of the trip depending on its distance, duration and tolls")), []string{"from", "to"})
+	promWazeEstimatedArrival = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("estimated_arrival_timestamp_seconds", "estimated time of arrival when leaving at the last refresh, in seconds since the epoch")), []string{"from", "to"})
+	promWazeRouteClosures = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("route_closures", "number of closed stretches on the route returned by Waze")), []string{"from", "to"})
+	promWazeTravelTimeQuantile = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("travel_time_window_seconds", "quantiles of the travel time in seconds over the configured window")), []string{"from", "to", "quantile"})
+	promWazeTravelTimeLastWeek = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("travel_time_last_week_seconds", "travel time in seconds recorded at the same time one week ago")), []string{"from", "to"})
+	promWazeTravelTimeRoutes = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("travel_time_routes_seconds", "minimum, maximum and mean travel time in seconds of all the routes returned")), []string{"from", "to", "stat"})
+	promWazeTravelTimeTypical = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("travel_time_typical_seconds", "typical travel time in seconds at the current time of the week")), []string{"from", "to"})
+	promWazeBestDeparture = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("best_departure_offset_seconds", "delay in seconds before the departure with the shortest predicted travel time in the look-ahead window")), []string{"from", "to"})
+	promWazeAvoidAreaViolation = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("avoid_area_violation", "1 if the route returned by Waze goes through the avoid area")), []string{"from", "to", "area"})
+	promWazePathInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("path_info", "information about the monitored path")), []string{"from", "to", "from_address", "to_address", "from_coordinates", "to_coordinates", "region", "vehicle", "avoid_toll", "avoid_subscription_road", "avoid_ferry", "avoid_unpaved"})
+	promWazeCalls = prometheus.NewCounterVec(prometheus.CounterOpts(opts("api_calls", "number of calls to the Waze API")), []string{"endpoint", "region", "status"})
+	promWazeParams = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("parameters_info", "Waze parameters, always 1")), []string{"region", "sleep", "vehicle", "avoid_toll", "avoid_subscription_road", "avoid_ferry", "avoid_unpaved", "interval", "deadline", "timeout", "geocoder", "language", "quantile_window", "last_week", "typical", "shuffle", "routes"})
+	promWazeTimeSpent = prometheus.NewCounterVec(prometheus.CounterOpts(opts("time_seconds", "total time spent to to process Waze API")), []string{"endpoint", "region"})
+	promWazeCacheHits = prometheus.NewCounter(prometheus.CounterOpts(opts("cache_hits", "number of results read from the shared cache instead of calling the Waze API")))
+	promWazeCoalescedCalls = prometheus.NewCounter(prometheus.CounterOpts(opts("coalesced_calls", "number of paths refreshed with the result of another path requesting the same route")))
+	promWazeConfiguredPaths = prometheus.NewGauge(prometheus.GaugeOpts(opts("configured_paths", "number of paths monitored by this instance")))
+	promWazeInterval = prometheus.NewGauge(prometheus.GaugeOpts(opts("effective_interval_seconds", "interval between two refreshes of all the paths, stretched while Waze API is overloaded")))
+	promWazeTimeouts = prometheus.NewCounter(prometheus.CounterOpts(opts("refresh_timeouts", "number of paths not refreshed because the deadline was exceeded")))
+	promWazeSchemaWarnings = prometheus.NewCounterVec(prometheus.CounterOpts(opts("response_schema_warnings_total", "number of unexpected changes of the structure of the Waze API responses")), []string{"kind"})
+	promWazeGeocodingRequests = prometheus.NewCounterVec(prometheus.CounterOpts(opts("geocoding_requests", "number of geocoding requests by status: success, not_found or error")), []string{"geocoder", "status"})
+	geocodingDurationOpts := opts("geocoding_duration_seconds", "duration of the geocoding requests in seconds")
+	promWazeGeocodingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: geocodingDurationOpts.Namespace,
+		Name:      geocodingDurationOpts.Name,
+		Help:      geocodingDurationOpts.Help,
+	}, []string{"geocoder"})
+	promWazeLeader = prometheus.NewGauge(prometheus.GaugeOpts(opts("leader", "1 if this replica is the leader which calls Waze API")))
+
+	for name := range names {
+		if !used[name] {
+			log.Fatalln("Unknown metric name:", name)
+		}
+	}
+}
+
 func (c *context) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range c.wazeMetrics {
 		metric.describe(ch)
 	}
-	c.wazeCallsOk.Describe(ch)
-	c.wazeCallsKo.Describe(ch)
-	c.wazeTimeSpent.Describe(ch)
+	c.wazeCalls.Describe(ch)
+	c.configuredPaths.Describe(ch)
+	if c.throttle != nil {
+		c.throttle.gauge.Describe(ch)
+	}
+	c.wazeTime.Describe(ch)
 	c.wazeParameters.Describe(ch)
+	c.wazeTimeouts.Describe(ch)
+	c.wazeCoalesced.Describe(ch)
+	c.geocodingRequests.Describe(ch)
+	c.geocodingDuration.Describe(ch)
+	c.schemaWarnings.Describe(ch)
+	if c.leader != nil {
+		c.wazeLeader.Describe(ch)
+	}
+	if c.cache != nil {
+		c.wazeCacheHits.Describe(ch)
+	}
 }
 
 func (c *context) Collect(ch chan<- prometheus.Metric) {
-	sleep := false
+	if c.interval == 0 {
+		c.refresh(false)
+	}
 	for _, metric := range c.wazeMetrics {
-		if sleep {
-			time.Sleep(c.sleepTime)
-		}
-		duration, err := metric.collect(ch)
-		if err == nil {
-			c.wazeCallsOk.Inc()
+		metric.collect(ch)
+	}
+	c.wazeCalls.Collect(ch)
+	c.configuredPaths.Collect(ch)
+	if c.throttle != nil {
+		c.throttle.gauge.Collect(ch)
+	}
+	c.wazeTime.Collect(ch)
+	c.wazeParameters.Collect(ch)
+	c.wazeTimeouts.Collect(ch)
+	c.wazeCoalesced.Collect(ch)
+	c.geocodingRequests.Collect(ch)
+	c.geocodingDuration.Collect(ch)
+	c.schemaWarnings.Collect(ch)
+	if c.leader != nil {
+		c.wazeLeader.Collect(ch)
+	}
+	if c.cache != nil {
+		c.wazeCacheHits.Collect(ch)
+	}
+}
+
+// refresh calls Waze API for all the paths, unless another replica is the
+// leader or their result is in the shared cache. The paths which cannot be
+// refreshed before the deadline keep their previous values. If paced, the
+// calls are spread across the interval instead of waiting for sleepTime
+func (c *context) refresh(paced bool) {
+	var deadline time.Time
+	if c.deadline > 0 {
+		deadline = time.Now().Add(c.deadline)
+	}
+
+	leader := true
+	if c.leader != nil {
+		leader = c.leader.campaign()
+		if leader {
+			c.wazeLeader.Set(1)
 		} else {
-			c.wazeCallsKo.Inc()
+			c.wazeLeader.Set(0)
+		}
+	}
+	metrics := c.wazeMetrics
+	if c.shuffle {
+		// so that the same paths are not always the ones left stale when the
+		// refresh is cut short
+		metrics = append([]*wazeMetric(nil), c.wazeMetrics...)
+		rand.Shuffle(len(metrics), func(i, j int) {
+			metrics[i], metrics[j] = metrics[j], metrics[i]
+		})
+	}
+	sleepTime := c.sleepTime
+	if paced {
+		sleepTime = c.pacedSleepTime(metrics)
+	}
+	coalesced := map[string]*coalescedRefresh{}
+	sleep := false
+	// once the deadline is exceeded, the paths which would call Waze API are
+	// counted but the ones in the cache or coalesced are still refreshed
+	skipped := 0
+	for _, metric := range metrics {
+		if !metric.schedule.active(time.Now()) {
+			continue
+		}
+		if metric.refreshFromCache() {
+			c.wazeCacheHits.Inc()
+			continue
+		}
+		if !leader {
+			continue
+		}
+		if shared, found := coalesced[metric.requestKey]; found {
+			// same route as a path already refreshed during this cycle
+			metric.apply(shared.call)
+			if shared.typical != nil {
+				metric.applyTypical(shared.typical)
+			}
+			if shared.bestDeparture >= 0 {
+				metric.bestDeparture.Set(shared.bestDeparture.Seconds())
+			}
+			c.wazeCoalesced.Inc()
+			continue
+		}
+		if sleep && skipped == 0 {
+			sdWatchdogSleep(sleepTime)
+		}
+		if skipped > 0 || (!deadline.IsZero() && time.Now().After(deadline)) {
+			skipped++
+			continue
+		}
+		shared := &coalescedRefresh{bestDeparture: -1}
+		shared.call = metric.refresh(deadline)
+		c.recordCall(shared.call.duration, shared.call.err)
+		if metric.typicalRequest != nil {
+			sdWatchdogSleep(sleepTime)
+			shared.typical = metric.refreshTypical(deadline)
+			c.recordCall(shared.typical.duration, shared.typical.err)
+		}
+		if metric.bestDeparture != nil {
+			shared.bestDeparture = metric.refreshBestDeparture(deadline, sleepTime, c.recordCall)
 		}
-		c.wazeTimeSpent.Add(duration.Seconds())
+		coalesced[metric.requestKey] = shared
+		sdWatchdog()
 		sleep = true
 	}
-	c.wazeCallsOk.Collect(ch)
-	c.wazeCallsKo.Collect(ch)
-	c.wazeTimeSpent.Collect(ch)
-	c.wazeParameters.Collect(ch)
+	if skipped > 0 {
+		log.Println("Deadline exceeded,", skipped, "paths are not refreshed")
+		c.wazeTimeouts.Add(float64(skipped))
+	}
+}
+
+// pacedSleepTime returns the time between two calls to spread the calls of
+// the active paths evenly across the interval, stretched by the throttle
+func (c *context) pacedSleepTime(metrics []*wazeMetric) time.Duration {
+	now := time.Now()
+	calls := 0
+	requests := map[string]bool{}
+	for _, metric := range metrics {
+		if !metric.schedule.active(now) || requests[metric.requestKey] {
+			continue
+		}
+		requests[metric.requestKey] = true
+		calls++
+		if metric.typicalRequest != nil {
+			calls++
+		}
+		calls += len(metric.departureRequests)
+	}
+	if calls == 0 {
+		return c.sleepTime
+	}
+	return c.nextInterval(c.throttle.current()) / time.Duration(calls)
+}
+
+// nextInterval returns the interval until the next refresh. It is
+// quietInterval during the quiet hours, but not beyond their end
+func (c *context) nextInterval(throttled time.Duration) time.Duration {
+	now := time.Now()
+	for _, quietHours := range c.quietHours {
+		if quietHours.contains(now) && c.quietInterval > throttled {
+			interval := quietHours.remaining(now)
+			if interval < throttled {
+				interval = throttled
+			}
+			if interval > c.quietInterval {
+				interval = c.quietInterval
+			}
+			return interval
+		}
+	}
+	return throttled
+}
+
+// poll refreshes all the paths every interval in the background. The first
+// refresh is done by the caller so the first scrape already has values
+func (c *context) poll(lastRefresh time.Time) {
+	for {
+		sdWatchdogSleep(c.nextInterval(c.throttle.adjust()) - time.Since(lastRefresh))
+		lastRefresh = time.Now()
+		c.refresh(c.pacing)
+	}
+}
+
+// filter returns a copy of the context which only collects the given paths
+func (c *context) filter(names []string) (*context, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = true
+	}
+
+	filtered := *c
+	filtered.wazeMetrics = nil
+	for _, metric := range c.wazeMetrics {
+		// a path may be given by its name or by <from>_<to>
+		fromTo := metric.from + "_" + metric.to
+		if wanted[metric.name] || wanted[fromTo] {
+			filtered.wazeMetrics = append(filtered.wazeMetrics, metric)
+			delete(wanted, metric.name)
+			delete(wanted, fromTo)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("Unknown path: %s", name)
+	}
+	return &filtered, nil
+}
+
+// metricsHandler serves all the metrics, or only the paths given by the
+// "paths" parameter such as /metrics?paths=home_office,office_home.
+// When the paths are refreshed during the scrape, the deadline is reduced to
+// fit in the scrape timeout sent by Prometheus
+func (c *context) metricsHandler() http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collector := c
+		if paths := r.URL.Query().Get("paths"); paths != "" {
+			filtered, err := c.filter(strings.Split(paths, ","))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			collector = filtered
+		}
+		if c.interval == 0 {
+			if timeout, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+				deadline := time.Duration(timeout*float64(time.Second)) - c.scrapeTimeoutOffset
+				if deadline <= 0 {
+					deadline = time.Millisecond
+				}
+				if collector.deadline == 0 || deadline < collector.deadline {
+					withDeadline := *collector
+					withDeadline.deadline = deadline
+					collector = &withDeadline
+				}
+			}
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+}
+
+// readyHandler returns 503 until all the paths have been refreshed
+// successfully at least once, or until the ready timeout has elapsed
+func (c *context) readyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		missing := []string{}
+		now := time.Now()
+		for _, metric := range c.wazeMetrics {
+			if metric.getLastUpdate().IsZero() && metric.schedule.active(now) {
+				missing = append(missing, metric.name)
+			}
+		}
+		if len(missing) > 0 && time.Since(c.startTime) < c.readyTimeout {
+			http.Error(w, "Not ready, no data yet for: "+strings.Join(missing, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "OK\n")
+	})
+}
+
+// healthHandler returns 503 if the data of all the paths is older than the
+// max age, which means that all the calls to Waze API are failing
+func (c *context) healthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.healthMaxAge > 0 {
+			// nothing is expected to be refreshed if no path is active
+			now := time.Now()
+			active := false
+			newest := c.startTime
+			for _, metric := range c.wazeMetrics {
+				active = active || metric.schedule.active(now)
+				if lastUpdate := metric.getLastUpdate(); lastUpdate.After(newest) {
+					newest = lastUpdate
+				}
+			}
+			if age := time.Since(newest); active && age > c.healthMaxAge {
+				http.Error(w, "Unhealthy, no data refreshed since "+age.Round(time.Second).String(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "OK\n")
+	})
+}
+
+func (c *context) recordCall(duration time.Duration, err error) {
+	if err == nil {
+		c.wazeCallsOk.Inc()
+	} else if errors.Is(err, waze.ErrNoRoute) {
+		c.wazeCallsNoRoute.Inc()
+	} else {
+		c.wazeCallsKo.Inc()
+	}
+	c.wazeTimeSpent.Add(duration.Seconds())
+	if c.throttle != nil {
+		c.throttle.observe(err)
+	}
+}
+
+func (c context) schemaWarning(kind string) {
+	c.schemaWarnings.WithLabelValues(kind).Inc()
 }
 
 func (w *wazeMetric) describe(ch chan<- *prometheus.Desc) {
 	w.timeTravelDistance.Describe(ch)
 	w.timeTravelTime.Describe(ch)
+	w.estimatedArrival.Describe(ch)
+	w.closures.Describe(ch)
+	w.pathInfo.Describe(ch)
+	for _, gauge := range w.timeTravelQuantile {
+		gauge.Describe(ch)
+	}
+	if w.timeTravelLastWeek != nil {
+		w.timeTravelLastWeek.Describe(ch)
+	}
+	if w.timeTravelTypical != nil {
+		w.timeTravelTypical.Describe(ch)
+	}
+	if w.co2Emissions != nil {
+		w.co2Emissions.Describe(ch)
+	}
+	for _, gauge := range w.timeTravelRoutes {
+		gauge.Describe(ch)
+	}
+	if w.tripCost != nil {
+		w.tripCost.Describe(ch)
+	}
+	if w.bestDeparture != nil {
+		w.bestDeparture.Describe(ch)
+	}
+	for _, area := range w.avoidAreas {
+		area.violation.Describe(ch)
+	}
+}
+
+// routingCall is the outcome of a call to Waze API
+type routingCall struct {
+	begin    time.Time
+	duration time.Duration
+	result   []waze.Result
+	err      error
+}
+
+// coalescedRefresh is the outcome of the refresh of a path, shared with the
+// other paths requesting the same route during the same cycle
+type coalescedRefresh struct {
+	call          *routingCall
+	typical       *routingCall
+	bestDeparture time.Duration // -1 if unknown
 }
 
-func (w *wazeMetric) collect(ch chan<- prometheus.Metric) (time.Duration, error) {
+func callWaze(request *waze.Request, deadline time.Time) *routingCall {
 	begin := time.Now()
-	result, err := w.wazeRequest.Call()
-	duration := time.Now().Sub(begin)
+	result, err := request.CallBefore(deadline)
+	return &routingCall{
+		begin:    begin,
+		duration: time.Now().Sub(begin),
+		result:   result,
+		err:      err,
+	}
+}
+
+func (w *wazeMetric) refresh(deadline time.Time) *routingCall {
+	call := callWaze(w.wazeRequest, deadline)
+	w.apply(call)
+	return call
+}
+
+// apply updates the values with the result of a call to Waze API
+func (w *wazeMetric) apply(call *routingCall) {
+	begin, result, err := call.begin, call.result, call.err
 	if err != nil {
-		// dont change the values
 		log.Println("Error", w.timeTravelTime.Desc().String(), err)
-	} else if len(result) > 0 {
+		if w.noRouteDown && errors.Is(err, waze.ErrNoRoute) {
+			w.timeTravelTime.Set(math.NaN())
+			w.timeTravelDistance.Set(math.NaN())
+			w.estimatedArrival.Set(math.NaN())
+			w.closures.Set(math.NaN())
+		}
+		// otherwise dont change the values
+	} else {
+		w.update(begin, result)
+		if w.cache != nil {
+			w.cache.set(w.name, &resultCacheEntry{Time: begin, Results: result})
+		}
+		w.outputs.send(w.refreshEvent(begin, result[0]))
+	}
+}
+
+func (w *wazeMetric) refreshEvent(t time.Time, result waze.Result) *refreshEvent {
+	event := &refreshEvent{
+		Path:            w.name,
+		From:            w.from,
+		To:              w.to,
+		Time:            t,
+		DurationSeconds: math.Round(result.Duration.Seconds()),
+		DistanceMeters:  result.Distance,
+	}
+	if typical, ok := w.lastTypical.Load().(float64); ok {
+		delay := event.DurationSeconds - typical
+		event.DelaySeconds = &delay
+	}
+	return event
+}
+
+// refreshFromCache updates the values from the shared cache if another
+// replica has refreshed the path
+func (w *wazeMetric) refreshFromCache() bool {
+	if w.cache == nil {
+		return false
+	}
+	entry, found := w.cache.get(w.name)
+	if !found {
+		return false
+	}
+	if entry.Time.After(w.getLastUpdate()) {
+		w.update(entry.Time, entry.Results)
+	}
+	return true
+}
+
+// getLastUpdate returns the time of the last successful refresh, or the zero
+// time if there is none
+func (w *wazeMetric) getLastUpdate() time.Time {
+	lastUpdate := atomic.LoadInt64(&w.lastUpdate)
+	if lastUpdate == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastUpdate)
+}
+
+func (w *wazeMetric) update(t time.Time, result []waze.Result) {
+	atomic.StoreInt64(&w.lastUpdate, t.UnixNano())
+	if len(result) > 0 {
 		w.timeTravelDistance.Set(float64(result[0].Distance))
+		w.lastResults.Store(result)
 		w.timeTravelTime.Set(math.Round(result[0].Duration.Seconds()))
+		w.estimatedArrival.Set(float64(t.Add(result[0].Duration).Unix()))
+		w.closures.Set(float64(result[0].Closures))
+		if w.co2Emissions != nil {
+			w.co2Emissions.Set(math.Round(float64(result[0].Distance) / 1000 * w.co2PerKm))
+		}
+		if w.tripCost != nil {
+			cost := float64(result[0].Distance)/1000*w.costPerKm + result[0].Duration.Minutes()*w.costPerMinute
+			if result[0].Toll {
+				cost += w.tollCost
+			}
+			w.tripCost.Set(cost)
+		}
+		if w.history != nil {
+			w.history.add(t, math.Round(result[0].Duration.Seconds()))
+		}
+		if w.timeTravelRoutes != nil {
+			min, max, sum := math.Inf(1), math.Inf(-1), 0.
+			for _, route := range result {
+				seconds := math.Round(route.Duration.Seconds())
+				min = math.Min(min, seconds)
+				max = math.Max(max, seconds)
+				sum += seconds
+			}
+			w.timeTravelRoutes[0].Set(min)
+			w.timeTravelRoutes[1].Set(max)
+			w.timeTravelRoutes[2].Set(math.Round(sum / float64(len(result))))
+		}
+		for _, area := range w.avoidAreas {
+			if area.intersects(&result[0]) {
+				area.violation.Set(1)
+			} else {
+				area.violation.Set(0)
+			}
+		}
+	}
+}
+
+func (w *wazeMetric) refreshTypical(deadline time.Time) *routingCall {
+	call := callWaze(w.typicalRequest, deadline)
+	w.applyTypical(call)
+	return call
+}
+
+func (w *wazeMetric) applyTypical(call *routingCall) {
+	if call.err != nil {
+		// dont change the value
+		log.Println("Error", w.timeTravelTypical.Desc().String(), call.err)
+	} else if len(call.result) > 0 {
+		w.lastTypical.Store(math.Round(call.result[0].Duration.Seconds()))
+		w.timeTravelTypical.Set(math.Round(call.result[0].Duration.Seconds()))
+	}
+}
+
+// refreshBestDeparture requests the candidate departures of the look-ahead
+// window, and compares them with the departure now. The failed candidates are
+// ignored. It returns the best offset, or -1 if unknown
+func (w *wazeMetric) refreshBestDeparture(deadline time.Time, sleepTime time.Duration, record func(time.Duration, error)) time.Duration {
+	best := time.Duration(-1)
+	bestDuration := time.Duration(0)
+	if results, ok := w.lastResults.Load().([]waze.Result); ok && len(results) > 0 {
+		best = 0
+		bestDuration = results[0].Duration
+	}
+	for i, request := range w.departureRequests {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		sdWatchdogSleep(sleepTime)
+		begin := time.Now()
+		result, err := request.CallBefore(deadline)
+		record(time.Now().Sub(begin), err)
+		if err != nil {
+			log.Println("Error", w.bestDeparture.Desc().String(), err)
+			continue
+		}
+		if best < 0 || result[0].Duration < bestDuration {
+			best = w.departureOffsets[i]
+			bestDuration = result[0].Duration
+		}
+	}
+	if best >= 0 {
+		w.bestDeparture.Set(best.Seconds())
+	}
+	return best
+}
+
+func (w *wazeMetric) collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	w.pathInfo.Collect(ch)
+	// no value until the path is refreshed, for instance on a standby replica
+	if w.getLastUpdate().IsZero() {
+		return
 	}
 	w.timeTravelDistance.Collect(ch)
 	w.timeTravelTime.Collect(ch)
-	return duration, err
+	w.estimatedArrival.Collect(ch)
+	w.closures.Collect(ch)
+	if w.history != nil {
+		since := now.Add(-w.quantileWindow)
+		for i, gauge := range w.timeTravelQuantile {
+			gauge.Set(w.history.quantile(since, travelTimeQuantiles[i]))
+			gauge.Collect(ch)
+		}
+	}
+	if w.timeTravelLastWeek != nil {
+		if value, found := w.history.at(now.Add(-week), lastWeekTolerance); found {
+			w.timeTravelLastWeek.Set(value)
+		} else {
+			w.timeTravelLastWeek.Set(math.NaN())
+		}
+		w.timeTravelLastWeek.Collect(ch)
+	}
+	if w.timeTravelTypical != nil {
+		w.timeTravelTypical.Collect(ch)
+	}
+	if w.co2Emissions != nil {
+		w.co2Emissions.Collect(ch)
+	}
+	for _, gauge := range w.timeTravelRoutes {
+		gauge.Collect(ch)
+	}
+	if w.tripCost != nil {
+		w.tripCost.Collect(ch)
+	}
+	if w.bestDeparture != nil {
+		w.bestDeparture.Collect(ch)
+	}
+	for _, area := range w.avoidAreas {
+		area.violation.Collect(ch)
+	}
 }
 
-func createWazeCoordinates(addresses map[string]string, region Region, client *http.Client) map[string]string {
+func createWazeCoordinates(addresses map[string]Address, geocoders *geocoders) map[string]string {
 	result := map[string]string{}
 	for name, address := range addresses {
-		coordinates, err := WazeAddressToQuery(address, region, client)
-		log.Println("Address", address, "has been found at", coordinates)
+		coordinates, err := geocoders.resolve(address)
 		if err != nil {
-			log.Fatalln("Failed to retrieve the address", address, err)
+			log.Fatalln("Failed to retrieve the address", address.String(), err)
+		}
+		log.Println("Address", address.String(), "has been found at", coordinates)
+		if err := validateCoordinates(coordinates, geocoders.region); err != nil {
+			log.Fatalln("Address", name, err)
 		}
 		result[name] = coordinates
 	}
 	return result
 }
 
-func getContext(filename string, client *http.Client) context {
+// newParameters returns the parameters of the Waze requests of a path
+func newParameters(jsonConfig *Config, path *Path, fromCoordinates string, toCoordinates string) waze.Parameters {
+	avoidUnpaved := jsonConfig.AvoidUnpaved
+	if path.AvoidUnpaved != nil {
+		avoidUnpaved = *path.AvoidUnpaved
+	}
+	return waze.Parameters{
+		FromCoordinates:       fromCoordinates,
+		ToCoordinates:         toCoordinates,
+		Region:                jsonConfig.Region,
+		Vehicle:               jsonConfig.Vehicle,
+		AvoidToll:             jsonConfig.AvoidToll,
+		AvoidSubscriptionRoad: jsonConfig.AvoidSubscriptionRoad,
+		AvoidFerry:            jsonConfig.AvoidFerry,
+		AvoidUnpaved:          avoidUnpaved,
+		Routes:                jsonConfig.Routes,
+		ExtraOptions:          jsonConfig.ExtraOptions,
+		ExtraParams:           jsonConfig.ExtraParams,
+	}
+}
+
+// newTransport returns the transport of the outbound requests. The settings
+// which are not configured keep the default values of Go
+func newTransport(jsonConfig *Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if jsonConfig.MaxIdleConns > 0 {
+		transport.MaxIdleConns = jsonConfig.MaxIdleConns
+	}
+	if jsonConfig.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = jsonConfig.MaxIdleConnsPerHost
+	}
+	if jsonConfig.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Second * time.Duration(jsonConfig.IdleConnTimeout)
+	}
+	if jsonConfig.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = time.Second * time.Duration(jsonConfig.TLSHandshakeTimeout)
+	}
+	if !jsonConfig.HTTP2 {
+		// a non nil empty map disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// getContext creates the context from the configuration. If restored is not
+// nil, the addresses and the paths which have not changed are loaded from it
+func getContext(filename string, client *http.Client, restored *state) context {
 	jsonConfig := NewConfig(filename)
+	client.Timeout = time.Second * time.Duration(jsonConfig.Timeout)
+	client.Transport = newTransport(jsonConfig)
+	if err := setLogLevel(jsonConfig.LogLevel); err != nil {
+		log.Fatalln(err)
+	}
+	if jsonConfig.LogFile != "" {
+		logFile, err := newRotatingFile(jsonConfig.LogFile,
+			jsonConfig.LogMaxSize*1024*1024,
+			time.Second*time.Duration(jsonConfig.LogMaxAge),
+			jsonConfig.LogMaxBackups)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.SetOutput(logFile)
+	}
+	if jsonConfig.Syslog != "" {
+		syslogWriter, err := newSyslogWriter(jsonConfig.Syslog, jsonConfig.SyslogFacility, jsonConfig.SyslogTag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if jsonConfig.LogFile != "" {
+			log.SetOutput(io.MultiWriter(log.Writer(), syslogWriter))
+		} else {
+			log.SetOutput(syslogWriter)
+		}
+	}
+	initMetrics(jsonConfig.MetricNames)
+	leader, err := newLeaderElector(jsonConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var cache *resultCache
+	if jsonConfig.RedisCache {
+		redisClient, err := newRedisClient(jsonConfig.RedisURL)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		cache = newResultCache(redisClient, jsonConfig.RedisCachePrefix, time.Second*time.Duration(jsonConfig.RedisCacheTTL))
+	}
+	var calendar *calendar
+	if jsonConfig.Calendar != "" {
+		calendar, err = newCalendar(jsonConfig.Calendar, client)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, path := range jsonConfig.Paths {
+			if path.CalendarEvent == "" {
+				continue
+			}
+			if summary := calendar.unsupported(regexp.MustCompile(path.CalendarEvent)); summary != "" {
+				log.Fatalln("Path", path.Name, "matches the recurring event", summary, "whose recurrence rule is not supported")
+			}
+		}
+		go calendar.watch(time.Second * time.Duration(jsonConfig.CalendarRefresh))
+	}
 
 	context := context{
-		sleepTime:     time.Millisecond * time.Duration(jsonConfig.Sleep),
-		listen:        jsonConfig.Listen,
-		wazeTimeSpent: promWazeTimeSpent,
-		wazeCallsOk:   promWazeCalls.WithLabelValues("ok"),
-		wazeCallsKo:   promWazeCalls.WithLabelValues("ko"),
+		sleepTime:           time.Millisecond * time.Duration(jsonConfig.Sleep),
+		interval:            time.Second * time.Duration(jsonConfig.Interval),
+		shuffle:             jsonConfig.Shuffle,
+		pacing:              jsonConfig.Pacing,
+		quietInterval:       time.Second * time.Duration(jsonConfig.QuietInterval),
+		textfile:            jsonConfig.Textfile,
+		configuredPaths:     promWazeConfiguredPaths,
+		adminToken:          jsonConfig.AdminToken,
+		startTime:           time.Now(),
+		readyTimeout:        time.Second * time.Duration(jsonConfig.ReadyTimeout),
+		healthMaxAge:        time.Second * time.Duration(jsonConfig.HealthMaxAge),
+		deadline:            time.Second * time.Duration(jsonConfig.Deadline),
+		scrapeTimeoutOffset: time.Duration(jsonConfig.ScrapeTimeoutOffset * float64(time.Second)),
+		wazeTimeouts:        promWazeTimeouts,
+		geocodingRequests:   promWazeGeocodingRequests,
+		geocodingDuration:   promWazeGeocodingDuration,
+		schemaWarnings:      promWazeSchemaWarnings,
+		wazeTime:            promWazeTimeSpent,
+		wazeTimeSpent:       promWazeTimeSpent.WithLabelValues("routing", jsonConfig.Region.String()),
+		wazeCalls:           promWazeCalls,
+		leader:              leader,
+		wazeLeader:          promWazeLeader,
+		cache:               cache,
+		wazeCacheHits:       promWazeCacheHits,
+		wazeCoalesced:       promWazeCoalescedCalls,
+		wazeCallsOk:         promWazeCalls.WithLabelValues("routing", jsonConfig.Region.String(), "ok"),
+		wazeCallsKo:         promWazeCalls.WithLabelValues("routing", jsonConfig.Region.String(), "ko"),
+		wazeCallsNoRoute:    promWazeCalls.WithLabelValues("routing", jsonConfig.Region.String(), "no_route"),
 		wazeParameters: promWazeParams.WithLabelValues(
 			jsonConfig.Region.String(),
 			strconv.FormatInt(jsonConfig.Sleep, 10),
@@ -143,11 +930,54 @@ func getContext(filename string, client *http.Client) context {
 			strconv.FormatBool(jsonConfig.AvoidToll),
 			strconv.FormatBool(jsonConfig.AvoidSubscriptionRoad),
 			strconv.FormatBool(jsonConfig.AvoidFerry),
+			strconv.FormatBool(jsonConfig.AvoidUnpaved),
+			strconv.FormatInt(jsonConfig.Interval, 10),
+			strconv.FormatInt(jsonConfig.Deadline, 10),
+			strconv.FormatInt(jsonConfig.Timeout, 10),
+			jsonConfig.Geocoder.String(),
+			jsonConfig.Language,
+			strconv.FormatInt(jsonConfig.QuantileWindow, 10),
+			strconv.FormatBool(jsonConfig.LastWeek),
+			strconv.FormatBool(jsonConfig.Typical),
+			strconv.FormatBool(jsonConfig.Shuffle),
+			strconv.Itoa(jsonConfig.Routes),
 		),
 	}
 
 	log.Println("Look for", len(jsonConfig.Addresses), "addresses")
-	coordinates := createWazeCoordinates(jsonConfig.Addresses, jsonConfig.Region, client)
+	geocoders := newGeocoders(jsonConfig, client)
+	coordinates := map[string]string{}
+	missing := map[string]Address{}
+	for name, address := range jsonConfig.Addresses {
+		if restored != nil && restored.Addresses[name] != nil && restored.Addresses[name].Address == address.String() {
+			coordinates[name] = restored.Addresses[name].Coordinates
+		} else {
+			missing[name] = address
+		}
+	}
+	for name, value := range createWazeCoordinates(missing, geocoders) {
+		coordinates[name] = value
+	}
+	context.addresses = map[string]*stateAddress{}
+	for name, address := range jsonConfig.Addresses {
+		context.addresses[name] = &stateAddress{Address: address.String(), Coordinates: coordinates[name]}
+	}
+
+	historyWindow := time.Second * time.Duration(jsonConfig.QuantileWindow)
+	if jsonConfig.LastWeek && historyWindow < week+lastWeekTolerance {
+		historyWindow = week + lastWeekTolerance
+	}
+
+	refreshOutputs, err := newOutputs(jsonConfig.Outputs, client)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(jsonConfig.ExecHook) > 0 {
+		refreshOutputs = append(refreshOutputs, &execOutput{
+			command: jsonConfig.ExecHook,
+			timeout: time.Second * time.Duration(jsonConfig.ExecHookTimeout),
+		})
+	}
 
 	log.Println("Create", len(jsonConfig.Paths), "paths")
 	for _, path := range jsonConfig.Paths {
@@ -159,44 +989,225 @@ func getContext(filename string, client *http.Client) context {
 		if !toFound {
 			log.Fatalln("Address not found:", path.To)
 		}
+		if fromCoordinates == toCoordinates {
+			log.Fatalln("Path", path.Name, "goes from", path.From, "to", path.To, "which are at the same coordinates", fromCoordinates)
+		}
 
+		wazeParameters := newParameters(jsonConfig, &path, fromCoordinates, toCoordinates)
 		wazeMetric := &wazeMetric{
-			wazeParameters: WazeParameters{
-				FromCoordinates:       fromCoordinates,
-				ToCoordinates:         toCoordinates,
-				Region:                jsonConfig.Region,
-				Vehicle:               jsonConfig.Vehicle,
-				AvoidToll:             jsonConfig.AvoidToll,
-				AvoidSubscriptionRoad: jsonConfig.AvoidSubscriptionRoad,
-				AvoidFerry:            jsonConfig.AvoidFerry,
-			},
+			name:               path.Name,
+			from:               path.From,
+			to:                 path.To,
+			outputs:            refreshOutputs,
+			cache:              cache,
+			noRouteDown:        jsonConfig.NoRouteDown,
+			schedule:           newSchedule(&path, jsonConfig.Holidays, calendar),
+			wazeParameters:     wazeParameters,
 			timeTravelTime:     promWazeTravelTime.WithLabelValues(path.From, path.To),
 			timeTravelDistance: promWazeTravelDistance.WithLabelValues(path.From, path.To),
+			estimatedArrival:   promWazeEstimatedArrival.WithLabelValues(path.From, path.To),
+			closures:           promWazeRouteClosures.WithLabelValues(path.From, path.To),
+			pathInfo: promWazePathInfo.WithLabelValues(
+				path.From,
+				path.To,
+				jsonConfig.Addresses[path.From].String(),
+				jsonConfig.Addresses[path.To].String(),
+				fromCoordinates,
+				toCoordinates,
+				jsonConfig.Region.String(),
+				jsonConfig.Vehicle.String(),
+				strconv.FormatBool(jsonConfig.AvoidToll),
+				strconv.FormatBool(jsonConfig.AvoidSubscriptionRoad),
+				strconv.FormatBool(jsonConfig.AvoidFerry),
+				strconv.FormatBool(wazeParameters.AvoidUnpaved),
+			),
+		}
+		wazeMetric.pathInfo.Set(1)
+		if historyWindow > 0 {
+			wazeMetric.history = newHistory(historyWindow)
+		}
+		if jsonConfig.QuantileWindow > 0 {
+			wazeMetric.quantileWindow = time.Second * time.Duration(jsonConfig.QuantileWindow)
+			for _, quantile := range travelTimeQuantiles {
+				wazeMetric.timeTravelQuantile = append(wazeMetric.timeTravelQuantile,
+					promWazeTravelTimeQuantile.WithLabelValues(path.From, path.To, strconv.FormatFloat(quantile, 'g', -1, 64)))
+			}
+		}
+		if jsonConfig.Routes > 1 {
+			for _, stat := range []string{"min", "max", "mean"} {
+				wazeMetric.timeTravelRoutes = append(wazeMetric.timeTravelRoutes, promWazeTravelTimeRoutes.WithLabelValues(path.From, path.To, stat))
+			}
+		}
+		if jsonConfig.LastWeek {
+			wazeMetric.timeTravelLastWeek = promWazeTravelTimeLastWeek.WithLabelValues(path.From, path.To)
+		}
+		wazeMetric.co2PerKm = jsonConfig.CO2PerKm
+		if path.CO2PerKm > 0 {
+			wazeMetric.co2PerKm = path.CO2PerKm
+		}
+		if wazeMetric.co2PerKm > 0 {
+			wazeMetric.co2Emissions = promWazeCO2Emissions.WithLabelValues(path.From, path.To)
+		}
+		if jsonConfig.CostPerKm > 0 || jsonConfig.CostPerMinute > 0 || path.TollCost > 0 {
+			wazeMetric.costPerKm = jsonConfig.CostPerKm
+			wazeMetric.costPerMinute = jsonConfig.CostPerMinute
+			wazeMetric.tollCost = path.TollCost
+			wazeMetric.tripCost = promWazeTripCost.WithLabelValues(path.From, path.To)
+		}
+		pathClient := &waze.Client{HTTP: client, Log: log.Println, Debug: logDebug}
+		if path.Timeout > 0 {
+			pathClient.HTTP = &http.Client{
+				Transport: client.Transport,
+				Timeout:   time.Second * time.Duration(path.Timeout),
+			}
 		}
 		var err error
-		wazeMetric.wazeRequest, err = CreateRequest(wazeMetric.wazeParameters, client)
+		wazeMetric.wazeRequest, err = waze.NewRequest(wazeMetric.wazeParameters, pathClient)
 		if err != nil {
 			log.Fatalln(err)
 		}
+		wazeMetric.wazeRequest.SchemaWarning = context.schemaWarning
+		wazeMetric.requestKey = fmt.Sprintf("%+v", wazeMetric.wazeParameters)
+		if jsonConfig.Typical {
+			// a departure one week ahead is the same time of the week, but too far
+			// for live traffic, so Waze answers with its historical statistics
+			typicalParameters := wazeMetric.wazeParameters
+			typicalParameters.DepartureOffset = week
+			wazeMetric.typicalRequest, err = waze.NewRequest(typicalParameters, pathClient)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			wazeMetric.typicalRequest.SchemaWarning = context.schemaWarning
+			wazeMetric.timeTravelTypical = promWazeTravelTimeTypical.WithLabelValues(path.From, path.To)
+		}
+		for _, area := range path.AvoidAreas {
+			wazeMetric.avoidAreas = append(wazeMetric.avoidAreas, &avoidArea{
+				name:      area,
+				polygon:   newPolygon(jsonConfig.AvoidAreas[area]),
+				violation: promWazeAvoidAreaViolation.WithLabelValues(path.From, path.To, area),
+			})
+		}
+		if jsonConfig.BestDepartureWindow > 0 {
+			step := time.Second * time.Duration(jsonConfig.BestDepartureStep)
+			window := time.Second * time.Duration(jsonConfig.BestDepartureWindow)
+			for offset := step; offset <= window; offset += step {
+				departureParameters := wazeMetric.wazeParameters
+				departureParameters.DepartureOffset = offset
+				request, err := waze.NewRequest(departureParameters, pathClient)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				request.SchemaWarning = context.schemaWarning
+				wazeMetric.departureRequests = append(wazeMetric.departureRequests, request)
+				wazeMetric.departureOffsets = append(wazeMetric.departureOffsets, offset)
+			}
+			wazeMetric.bestDeparture = promWazeBestDeparture.WithLabelValues(path.From, path.To)
+		}
+		if restored != nil && restored.Paths[path.Name] != nil {
+			wazeMetric.restore(restored.Paths[path.Name])
+		}
 		context.wazeMetrics = append(context.wazeMetrics, wazeMetric)
 	}
 
-	context.wazeParameters.Inc()
+	for _, quietHours := range jsonConfig.QuietHours {
+		// already checked with the configuration
+		r, _ := parseTimeRange(quietHours)
+		context.quietHours = append(context.quietHours, r)
+	}
+	context.listeners = newListeners(jsonConfig)
+	context.configuredPaths.Set(float64(len(context.wazeMetrics)))
+	if context.shuffle {
+		rand.Seed(time.Now().UnixNano())
+	}
+	if context.interval > 0 {
+		context.throttle = newThrottle(context.interval, time.Second*time.Duration(jsonConfig.ThrottleMaxInterval), promWazeInterval)
+	}
+	context.wazeParameters.Set(1)
+
+	// the context is registered for each scrape, so check once for conflicting
+	// descriptors, including with the Go and process metrics
+	if err := prometheus.DefaultRegisterer.Register(&context); err != nil {
+		log.Fatalln("Invalid metrics:", err)
+	}
+	prometheus.DefaultRegisterer.Unregister(&context)
 	return context
 }
 
 func main() {
-	if len(os.Args) != 2 {
+	var configFile string
+	var restored *state
+	switch {
+	case len(os.Args) == 2:
+		configFile = os.Args[1]
+	case len(os.Args) == 4 && os.Args[1] == "snapshot":
+		snapshotCommand(os.Args[2], os.Args[3])
+		return
+	case len(os.Args) == 3 && os.Args[1] == "selftest":
+		selftestCommand(os.Args[2])
+		return
+	case len(os.Args) == 4 && os.Args[1] == "backfill":
+		backfillCommand(os.Args[2], os.Args[3])
+		return
+	case len(os.Args) == 4 && os.Args[1] == "restore":
+		configFile = os.Args[2]
+		restored = readState(os.Args[3])
+	default:
 		fmt.Fprintln(os.Stderr, "Usage", os.Args[0], "<config_file>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "snapshot <config_file> <state_file>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "restore <config_file> <state_file>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "selftest <config_file>")
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], "backfill <config_file> <openmetrics_file>")
 		os.Exit(1)
 	}
 
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	client := &http.Client{}
+	context := getContext(configFile, client, restored)
+	if context.textfile != "" {
+		if context.interval == 0 {
+			// one-shot, for instance from cron
+			if err := context.writeTextfile(context.textfile); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+		context.pollTextfile(context.textfile)
 	}
-	context := getContext(os.Args[1], client)
 
-	prometheus.MustRegister(&context)
-	http.Handle("/metrics", promhttp.Handler())
-	log.Println(http.ListenAndServe(context.listen, nil))
+	endpoints := map[string]http.Handler{
+		"/metrics":          context.metricsHandler(),
+		"/ready":            context.readyHandler(),
+		"/healthz":          context.healthHandler(),
+		"/dashboard":        dashboardHandler(),
+		"/dashboard/status": context.dashboardStatusHandler(),
+		"/api/v1/routes":    context.routesHandler(),
+		"/api/v1/backfill":  context.backfillHandler(),
+	}
+	if context.adminToken != "" {
+		endpoints["/-/loglevel"] = requireToken(context.adminToken, logLevelHandler())
+		endpoints["/-/snapshot"] = requireToken(context.adminToken, context.snapshotHandler())
+	}
+	handlers := []http.Handler{}
+	for i := range context.listeners {
+		handler, err := context.listeners[i].handler(endpoints)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		handlers = append(handlers, handler)
+	}
+	if context.interval > 0 {
+		log.Println("Refresh all the paths before serving")
+		begin := time.Now()
+		// not paced, so that the exporter is ready as soon as possible
+		context.refresh(false)
+		go context.poll(begin)
+	} else if sdWatchdogInterval > 0 {
+		// without interval, the exporter is idle while it is not scraped
+		go func() {
+			for {
+				time.Sleep(sdWatchdogInterval / 2)
+				sdWatchdog()
+			}
+		}()
+	}
+	log.Println(serve(context.listeners, handlers))
 }
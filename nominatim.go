@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+const (
+	nominatimDefaultURL = "https://nominatim.openstreetmap.org/search"
+	nominatimUserAgent  = "prometheus-waze-exporter"
+	// the usage policy allows at most 1 request per second
+	nominatimInterval = time.Second
+	// the maximum number of candidates returned by Nominatim
+	nominatimMaxLimit = 40
+)
+
+// Nominatim is an OpenStreetMap geocoder, see
+// https://operations.osmfoundation.org/policies/nominatim/
+type Nominatim struct {
+	client   *http.Client
+	url      string
+	email    string
+	language string
+	lastCall time.Time
+}
+
+func NewNominatim(nominatimURL string, email string, language string, client *http.Client) *Nominatim {
+	if nominatimURL == "" {
+		nominatimURL = nominatimDefaultURL
+	}
+	return &Nominatim{
+		client:   client,
+		url:      nominatimURL,
+		email:    email,
+		language: language,
+	}
+}
+
+func (n *Nominatim) AddressToQuery(address string, filter waze.AddressFilter) (string, error) {
+	if wait := nominatimInterval - time.Since(n.lastCall); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() {
+		n.lastCall = time.Now()
+	}()
+
+	param := url.Values{}
+	param.Set("q", address)
+	param.Set("format", "jsonv2")
+	param.Set("addressdetails", "1")
+	// the candidates are filtered here, so more of them are needed
+	limit := filter.Index + 1
+	if filter.Country != "" || filter.Contains != "" {
+		limit = nominatimMaxLimit
+	}
+	if limit > nominatimMaxLimit {
+		limit = nominatimMaxLimit
+	}
+	param.Set("limit", strconv.Itoa(limit))
+	if n.email != "" {
+		param.Set("email", n.email)
+	}
+	if n.language != "" {
+		param.Set("accept-language", n.language)
+	}
+	if len(filter.BoundingBox) == 4 {
+		param.Set("viewbox", fmt.Sprintf("%f,%f,%f,%f", filter.BoundingBox[0], filter.BoundingBox[1], filter.BoundingBox[2], filter.BoundingBox[3]))
+		param.Set("bounded", "1")
+	}
+
+	u := n.url + "?" + param.Encode()
+	logDebug("Call", u)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", nominatimUserAgent)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("Got HTTP %d %s", resp.StatusCode, resp.Status)
+	}
+
+	decodedResponse := []nominatimResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decodedResponse); err != nil {
+		return "", err
+	}
+	index := filter.Index
+	for _, item := range decodedResponse {
+		if !item.match(filter) {
+			logDebug("Skip candidate", item.DisplayName)
+			continue
+		}
+		if index > 0 {
+			logDebug("Skip candidate", item.DisplayName)
+			index--
+			continue
+		}
+		lat, err := strconv.ParseFloat(item.Lat, 64)
+		if err != nil {
+			return "", err
+		}
+		lon, err := strconv.ParseFloat(item.Lon, 64)
+		if err != nil {
+			return "", err
+		}
+		log.Println("Select candidate", item.DisplayName, lon, lat)
+		return waze.CoordinatesToQuery(lon, lat), nil
+	}
+
+	if len(decodedResponse) > 0 {
+		return "", fmt.Errorf("%w, no candidate out of %d matches the selection rules: %s", waze.ErrAddressNotFound, len(decodedResponse), address)
+	}
+	return "", fmt.Errorf("%w: %s", waze.ErrAddressNotFound, address)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// nominatimResponse
+////////////////////////////////////////////////////////////////////////////////
+
+type nominatimResponse struct {
+	Lat         string `json:"lat"`
+	Lon         string `json:"lon"`
+	DisplayName string `json:"display_name"`
+	Address     struct {
+		Country     string `json:"country"`
+		CountryCode string `json:"country_code"`
+	} `json:"address"`
+}
+
+// match applies the selection rules which Nominatim does not support. The
+// country may be its name or its ISO 3166-1 code
+func (n *nominatimResponse) match(filter waze.AddressFilter) bool {
+	if filter.Country != "" && !strings.EqualFold(filter.Country, n.Address.Country) && !strings.EqualFold(filter.Country, n.Address.CountryCode) {
+		return false
+	}
+	if filter.Contains != "" && !strings.Contains(strings.ToLower(n.DisplayName), strings.ToLower(filter.Contains)) {
+		return false
+	}
+	return true
+}
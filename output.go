@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// refreshEvent is the result of a refresh, sent to the outputs
+type refreshEvent struct {
+	Path            string    `json:"path"`
+	From            string    `json:"from"`
+	To              string    `json:"to"`
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	DistanceMeters  int       `json:"distance_meters"`
+	// DelaySeconds is the difference with the typical travel time, if known
+	DelaySeconds *float64 `json:"delay_seconds,omitempty"`
+}
+
+// output sends the results to another destination than Prometheus. send must
+// not block the refresh
+type output interface {
+	send(event *refreshEvent)
+}
+
+// outputFactory creates an output from its configuration, which is the JSON
+// object of the output in "outputs"
+type outputFactory func(config json.RawMessage, client *http.Client) (output, error)
+
+var outputFactories = map[string]outputFactory{}
+
+// registerOutput makes an output type available in the configuration. It is
+// called by the init function of each output
+func registerOutput(name string, factory outputFactory) {
+	outputFactories[name] = factory
+}
+
+// outputs sends the results to all the configured outputs
+type outputs []output
+
+func newOutputs(configs []json.RawMessage, client *http.Client) (outputs, error) {
+	result := outputs{}
+	for _, config := range configs {
+		header := struct {
+			Type string `json:"type"`
+		}{}
+		if err := json.Unmarshal(config, &header); err != nil {
+			return nil, err
+		}
+		factory, found := outputFactories[header.Type]
+		if !found {
+			return nil, fmt.Errorf("Unknown output type: %q", header.Type)
+		}
+		output, err := factory(config, client)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s output: %w", header.Type, err)
+		}
+		result = append(result, output)
+	}
+	return result, nil
+}
+
+func (o outputs) send(event *refreshEvent) {
+	for _, output := range o {
+		output.send(event)
+	}
+}
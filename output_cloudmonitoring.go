@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerOutput("cloud_monitoring", newCloudMonitoringOutput)
+}
+
+// gcpMetadataURL is the metadata server of Google Compute Engine
+const gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+
+// cloudMonitoringOutput writes the result of each refresh as custom metrics
+// in Google Cloud Monitoring. It authenticates with the service account of
+// the VM
+type cloudMonitoringOutput struct {
+	projectID string
+	prefix    string
+	client    *http.Client
+	metadata  *http.Client
+
+	mutex       sync.Mutex
+	accessToken string
+	expiration  time.Time
+}
+
+func newCloudMonitoringOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		ProjectID    string `json:"project_id"`
+		MetricPrefix string `json:"metric_prefix"`
+		Timeout      int64  `json:"timeout"`
+	}{
+		MetricPrefix: "custom.googleapis.com/waze",
+		Timeout:      10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded.MetricPrefix == "" {
+		return nil, errors.New("metric_prefix is required")
+	}
+	timeout := time.Second * time.Duration(decoded.Timeout)
+	return &cloudMonitoringOutput{
+		projectID: decoded.ProjectID,
+		prefix:    strings.TrimSuffix(decoded.MetricPrefix, "/"),
+		client: &http.Client{
+			Transport: client.Transport,
+			Timeout:   timeout,
+		},
+		// the metadata server is local, it must not go through a proxy
+		metadata: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (c *cloudMonitoringOutput) send(event *refreshEvent) {
+	values := map[string]float64{
+		"travel_time_seconds": event.DurationSeconds,
+		"route_length_meters": float64(event.DistanceMeters),
+	}
+	if event.DelaySeconds != nil {
+		values["delay_seconds"] = *event.DelaySeconds
+	}
+	go func() {
+		if err := c.write(event, values); err != nil {
+			log.Println("Could not write the result of", event.Path, "to Cloud Monitoring:", err)
+		}
+	}()
+}
+
+func (c *cloudMonitoringOutput) write(event *refreshEvent, values map[string]float64) error {
+	token, projectID, err := c.getToken()
+	if err != nil {
+		return err
+	}
+
+	type point struct {
+		Interval struct {
+			EndTime string `json:"endTime"`
+		} `json:"interval"`
+		Value struct {
+			DoubleValue float64 `json:"doubleValue"`
+		} `json:"value"`
+	}
+	type timeSeries struct {
+		Metric struct {
+			Type   string            `json:"type"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metric"`
+		Resource struct {
+			Type   string            `json:"type"`
+			Labels map[string]string `json:"labels"`
+		} `json:"resource"`
+		MetricKind string  `json:"metricKind"`
+		ValueType  string  `json:"valueType"`
+		Points     []point `json:"points"`
+	}
+	request := struct {
+		TimeSeries []timeSeries `json:"timeSeries"`
+	}{}
+	for name, value := range values {
+		series := timeSeries{
+			MetricKind: "GAUGE",
+			ValueType:  "DOUBLE",
+			Points:     []point{{}},
+		}
+		series.Metric.Type = c.prefix + "/" + name
+		series.Metric.Labels = map[string]string{
+			"name": event.Path,
+			"from": event.From,
+			"to":   event.To,
+		}
+		series.Resource.Type = "global"
+		series.Resource.Labels = map[string]string{"project_id": projectID}
+		series.Points[0].Interval.EndTime = event.Time.UTC().Format(time.RFC3339)
+		series.Points[0].Value.DoubleValue = value
+		request.TimeSeries = append(request.TimeSeries, series)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", "https://monitoring.googleapis.com/v3/projects/"+projectID+"/timeSeries", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Got HTTP %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// getToken returns the access token of the service account of the VM, and
+// the project, taken from the metadata server if not configured
+func (c *cloudMonitoringOutput) getToken() (string, string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.projectID == "" {
+		projectID, err := c.getMetadata("/project/project-id")
+		if err != nil {
+			return "", "", fmt.Errorf("No project_id and no metadata server: %w", err)
+		}
+		c.projectID = strings.TrimSpace(string(projectID))
+	}
+	if c.accessToken != "" && time.Until(c.expiration) > 5*time.Minute {
+		return c.accessToken, c.projectID, nil
+	}
+	body, err := c.getMetadata("/instance/service-accounts/default/token")
+	if err != nil {
+		return "", "", err
+	}
+	decoded := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return "", "", err
+	}
+	c.accessToken = decoded.AccessToken
+	c.expiration = time.Now().Add(time.Second * time.Duration(decoded.ExpiresIn))
+	return c.accessToken, c.projectID, nil
+}
+
+func (c *cloudMonitoringOutput) getMetadata(path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", gcpMetadataURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := c.metadata.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got HTTP %s from %s", resp.Status, req.URL)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerOutput("cloudwatch", newCloudWatchOutput)
+}
+
+// awsMetadataURL is the instance metadata service of EC2 (IMDSv2)
+const awsMetadataURL = "http://169.254.169.254/latest"
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	expiration      time.Time // zero if static
+}
+
+// cloudWatchOutput puts the result of each refresh as custom metrics in AWS
+// CloudWatch, with the dimension Path
+type cloudWatchOutput struct {
+	region    string
+	namespace string
+	client    *http.Client
+	metadata  *http.Client
+
+	mutex       sync.Mutex
+	credentials *awsCredentials
+}
+
+func newCloudWatchOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		Region          string `json:"region"`
+		Namespace       string `json:"namespace"`
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+		Timeout         int64  `json:"timeout"`
+	}{
+		Region:    os.Getenv("AWS_REGION"),
+		Namespace: "Waze",
+		Timeout:   10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded.Region == "" {
+		return nil, errors.New("region is required")
+	}
+	if decoded.Namespace == "" {
+		return nil, errors.New("namespace is required")
+	}
+	timeout := time.Second * time.Duration(decoded.Timeout)
+	result := &cloudWatchOutput{
+		region:    decoded.Region,
+		namespace: decoded.Namespace,
+		client: &http.Client{
+			Transport: client.Transport,
+			Timeout:   timeout,
+		},
+		// the metadata service is local, it must not go through a proxy
+		metadata: &http.Client{Timeout: timeout},
+	}
+	if decoded.AccessKeyID == "" {
+		decoded.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		decoded.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if decoded.AccessKeyID != "" {
+		result.credentials = &awsCredentials{
+			accessKeyID:     decoded.AccessKeyID,
+			secretAccessKey: decoded.SecretAccessKey,
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return result, nil
+}
+
+func (c *cloudWatchOutput) send(event *refreshEvent) {
+	form := url.Values{}
+	form.Set("Action", "PutMetricData")
+	form.Set("Version", "2010-08-01")
+	form.Set("Namespace", c.namespace)
+	metrics := []struct {
+		name  string
+		value float64
+		unit  string
+	}{
+		{"TravelTime", event.DurationSeconds, "Seconds"},
+		{"RouteLength", float64(event.DistanceMeters), "None"},
+	}
+	if event.DelaySeconds != nil {
+		metrics = append(metrics, struct {
+			name  string
+			value float64
+			unit  string
+		}{"Delay", *event.DelaySeconds, "Seconds"})
+	}
+	for i, metric := range metrics {
+		prefix := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(prefix+"MetricName", metric.name)
+		form.Set(prefix+"Value", strconv.FormatFloat(metric.value, 'f', -1, 64))
+		form.Set(prefix+"Unit", metric.unit)
+		form.Set(prefix+"Timestamp", event.Time.UTC().Format(time.RFC3339))
+		form.Set(prefix+"Dimensions.member.1.Name", "Path")
+		form.Set(prefix+"Dimensions.member.1.Value", event.Path)
+	}
+	go func() {
+		if err := c.put(form.Encode()); err != nil {
+			log.Println("Could not put the result of", event.Path, "to CloudWatch:", err)
+		}
+	}()
+}
+
+func (c *cloudWatchOutput) put(body string) error {
+	credentials, err := c.getCredentials()
+	if err != nil {
+		return err
+	}
+	host := "monitoring." + c.region + ".amazonaws.com"
+	req, err := http.NewRequest("POST", "https://"+host+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(req, body, host, c.region, "monitoring", credentials, time.Now())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Got HTTP %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// getCredentials returns the static credentials if any, or else the ones of
+// the role of the EC2 instance, refreshed before they expire
+func (c *cloudWatchOutput) getCredentials() (*awsCredentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.credentials != nil && (c.credentials.expiration.IsZero() || time.Until(c.credentials.expiration) > 5*time.Minute) {
+		return c.credentials, nil
+	}
+
+	req, err := http.NewRequest("PUT", awsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := c.getMetadata(req)
+	if err != nil {
+		return nil, fmt.Errorf("No credentials and no instance metadata: %w", err)
+	}
+	const credentialsURL = awsMetadataURL + "/meta-data/iam/security-credentials/"
+	req, _ = http.NewRequest("GET", credentialsURL, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := c.getMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+	req, _ = http.NewRequest("GET", credentialsURL+strings.TrimSpace(role), nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	body, err := c.getMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+	decoded := struct {
+		AccessKeyID     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}{}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		return nil, err
+	}
+	c.credentials = &awsCredentials{
+		accessKeyID:     decoded.AccessKeyID,
+		secretAccessKey: decoded.SecretAccessKey,
+		sessionToken:    decoded.Token,
+		expiration:      decoded.Expiration,
+	}
+	return c.credentials, nil
+}
+
+func (c *cloudWatchOutput) getMetadata(req *http.Request) (string, error) {
+	resp, err := c.metadata.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Got HTTP %s from %s", resp.Status, req.URL)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// signAWSRequest adds the headers of the signature version 4 of AWS
+func signAWSRequest(req *http.Request, body, host, region, service string, credentials *awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\nhost:" + host + "\nx-amz-date:" + amzDate + "\n"
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + credentials.sessionToken + "\n"
+	}
+	bodyHash := sha256.Sum256([]byte(body))
+	canonicalRequest := req.Method + "\n/\n\n" + canonicalHeaders + "\n" + signedHeaders + "\n" + hex.EncodeToString(bodyHash[:])
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + credentials.secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credentials.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(key))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test vector post-x-www-form-urlencoded of the AWS Signature Version 4
+// test suite
+func TestSignAWSRequest(t *testing.T) {
+	const body = "Param1=value1"
+	credentials := &awsCredentials{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	req, err := http.NewRequest("POST", "https://example.amazonaws.com/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	signAWSRequest(req, body, "example.amazonaws.com", "us-east-1", "service", credentials, now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("got %s, expected %s", got, expected)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("got X-Amz-Date %s", got)
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "" {
+		t.Error("unexpected X-Amz-Security-Token")
+	}
+}
+
+func TestSignAWSRequestSessionToken(t *testing.T) {
+	credentials := &awsCredentials{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		sessionToken:    "token",
+	}
+	req, err := http.NewRequest("POST", "https://monitoring.eu-west-3.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signAWSRequest(req, "", "monitoring.eu-west-3.amazonaws.com", "eu-west-3", "monitoring", credentials, time.Now())
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("got X-Amz-Security-Token %s", got)
+	}
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("the session token is not signed: %s", got)
+	}
+}
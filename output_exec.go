@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerOutput("exec", newExecOutput)
+}
+
+// execOutput runs an external command after each refresh, with the result on
+// its standard input
+type execOutput struct {
+	command []string
+	timeout time.Duration
+}
+
+func newExecOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		Command []string `json:"command"`
+		Timeout int64    `json:"timeout"`
+	}{
+		Timeout: 10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	if len(decoded.Command) == 0 {
+		return nil, errors.New("command is required")
+	}
+	if decoded.Timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	return &execOutput{
+		command: decoded.Command,
+		timeout: time.Second * time.Duration(decoded.Timeout),
+	}, nil
+}
+
+// send starts the command in the background, so a slow command does not delay
+// the refresh
+func (h *execOutput) send(event *refreshEvent) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Could not encode the result of", event.Path, err)
+		return
+	}
+	go func() {
+		output := bytes.Buffer{}
+		cmd := exec.Command(h.command[0], h.command[1:]...)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := cmd.Start(); err != nil {
+			log.Println("Could not run", h.command[0], err)
+			return
+		}
+		timer := time.AfterFunc(h.timeout, func() {
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+		if err := cmd.Wait(); err != nil {
+			log.Println("The hook", h.command[0], "failed for", event.Path, err, strings.TrimSpace(output.String()))
+			return
+		}
+		logDebug("The hook", h.command[0], "succeeded for", event.Path)
+	}()
+}
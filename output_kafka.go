@@ -0,0 +1,73 @@
+package main
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func init() {
+	registerOutput("kafka", newKafkaOutput)
+}
+
+// kafkaOutput publishes the result of each refresh as a JSON message to a
+// Kafka topic. The messages are keyed by path so that the results of a path
+// stay in the same partition
+type kafkaOutput struct {
+	writer *kafka.Writer
+}
+
+func newKafkaOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		Brokers []string `json:"brokers"`
+		Topic   string   `json:"topic"`
+		Timeout int64    `json:"timeout"`
+	}{
+		Timeout: 10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	if len(decoded.Brokers) == 0 {
+		return nil, errors.New("brokers is required")
+	}
+	if decoded.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	timeout := time.Second * time.Duration(decoded.Timeout)
+	return &kafkaOutput{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(decoded.Brokers...),
+			Topic:        decoded.Topic,
+			Balancer:     &kafka.Hash{},
+			WriteTimeout: timeout,
+			ReadTimeout:  timeout,
+			Async:        true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					log.Println("Could not publish", len(messages), "message(s) to Kafka:", err)
+				}
+			},
+		},
+	}, nil
+}
+
+func (k *kafkaOutput) send(event *refreshEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Could not encode the result of", event.Path, err)
+		return
+	}
+	// asynchronous writer: this only queues the message
+	if err := k.writer.WriteMessages(stdcontext.Background(), kafka.Message{
+		Key:   []byte(event.Path),
+		Value: body,
+	}); err != nil {
+		log.Println("Could not publish the result of", event.Path, err)
+	}
+}
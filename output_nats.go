@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerOutput("nats", newNATSOutput)
+}
+
+// natsOutput publishes the result of each refresh as JSON to the subject
+// <subject_prefix>.<from>.<to> of a NATS server. The refreshes are far apart,
+// so it opens a connection for each message instead of keeping one alive
+type natsOutput struct {
+	address  string
+	prefix   string
+	user     string
+	password string
+	token    string
+	timeout  time.Duration
+}
+
+func newNATSOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		URL           string `json:"url"`
+		SubjectPrefix string `json:"subject_prefix"`
+		Token         string `json:"token"`
+		Timeout       int64  `json:"timeout"`
+	}{
+		URL:           "nats://localhost:4222",
+		SubjectPrefix: "waze.routes",
+		Timeout:       10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(decoded.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid url %q, expecting nats://host:port", decoded.URL)
+	}
+	if decoded.SubjectPrefix == "" {
+		return nil, errors.New("subject_prefix is required")
+	}
+	result := &natsOutput{
+		address: u.Host,
+		prefix:  decoded.SubjectPrefix,
+		token:   decoded.Token,
+		timeout: time.Second * time.Duration(decoded.Timeout),
+	}
+	if u.Port() == "" {
+		result.address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		result.user = u.User.Username()
+		result.password, _ = u.User.Password()
+	}
+	return result, nil
+}
+
+// natsToken makes a valid subject token of any string: the separators and
+// wildcards of NATS are replaced
+func natsToken(value string) string {
+	token := strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+	if token == "" {
+		return "_"
+	}
+	return token
+}
+
+func (n *natsOutput) send(event *refreshEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Could not encode the result of", event.Path, err)
+		return
+	}
+	subject := n.prefix + "." + natsToken(event.From) + "." + natsToken(event.To)
+	go func() {
+		if err := n.publish(subject, body); err != nil {
+			log.Println("Could not publish the result of", event.Path, "to NATS:", err)
+		}
+	}()
+}
+
+func (n *natsOutput) publish(subject string, body []byte) error {
+	conn, err := net.DialTimeout("tcp", n.address, n.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	reader := bufio.NewReader(conn)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("Unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	connect, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		User     string `json:"user,omitempty"`
+		Password string `json:"pass,omitempty"`
+		Token    string `json:"auth_token,omitempty"`
+	}{
+		Name:     "prometheus-waze-exporter",
+		User:     n.user,
+		Password: n.password,
+		Token:    n.token,
+	})
+	if err != nil {
+		return err
+	}
+	// the PING makes sure the server processed the message before closing
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connect, subject, len(body), body); err != nil {
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(line)
+		}
+	}
+}
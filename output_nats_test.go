@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection, checks the exchange and answers to the
+// PING with the given reply, or closes the connection if it is empty
+func fakeNATS(t *testing.T, reply string) (string, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	done := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+		reader := bufio.NewReader(conn)
+		lines := []string{}
+		for len(lines) < 4 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				done <- err
+				return
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+		}
+
+		connect := struct {
+			Verbose bool   `json:"verbose"`
+			Name    string `json:"name"`
+			User    string `json:"user"`
+			Pass    string `json:"pass"`
+		}{}
+		if !strings.HasPrefix(lines[0], "CONNECT ") {
+			t.Errorf("expected CONNECT, got %q", lines[0])
+		} else if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "CONNECT ")), &connect); err != nil {
+			t.Error(err)
+		} else if connect.Verbose || connect.Name != "prometheus-waze-exporter" || connect.User != "user" || connect.Pass != "password" {
+			t.Errorf("unexpected CONNECT %+v", connect)
+		}
+		if lines[1] != "PUB waze.routes.home_town.work 12" {
+			t.Errorf("unexpected %q", lines[1])
+		}
+		if lines[2] != `{"path":"a"}` {
+			t.Errorf("unexpected payload %q", lines[2])
+		}
+		if lines[3] != "PING" {
+			t.Errorf("expected PING, got %q", lines[3])
+		}
+		if reply != "" {
+			conn.Write([]byte(reply))
+			io.Copy(io.Discard, reader)
+		}
+		done <- nil
+	}()
+	return listener.Addr().String(), done
+}
+
+func TestNATSPublish(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		err   string
+	}{
+		{name: "pong", reply: "PONG\r\n"},
+		{name: "ok then pong", reply: "+OK\r\nPONG\r\n"},
+		{name: "error", reply: "-ERR 'Authorization Violation'\r\n", err: "-ERR 'Authorization Violation'"},
+		{name: "closed", reply: "", err: "EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, done := fakeNATS(t, test.reply)
+			config := `{"url": "nats://user:password@` + address + `", "timeout": 1}`
+			output, err := newNATSOutput(json.RawMessage(config), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = output.(*natsOutput).publish("waze.routes."+natsToken("home.town")+".work", []byte(`{"path":"a"}`))
+			if test.err == "" && err != nil {
+				t.Errorf("got error %v", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v, expected %s", err, test.err)
+			}
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNATSGreeting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Write([]byte("HELLO\r\n"))
+			conn.Close()
+		}
+	}()
+	n := &natsOutput{address: listener.Addr().String(), timeout: time.Second}
+	if err := n.publish("subject", []byte("body")); err == nil || !strings.Contains(err.Error(), "Unexpected greeting") {
+		t.Errorf("got error %v, expected an unexpected greeting", err)
+	}
+}
+
+func TestNATSToken(t *testing.T) {
+	for value, expected := range map[string]string{
+		"paris":        "paris",
+		"home.town":    "home_town",
+		"a*b>c d\te":   "a_b_c_d_e",
+		"":             "_",
+		"gare_de_lyon": "gare_de_lyon",
+	} {
+		if got := natsToken(value); got != expected {
+			t.Errorf("natsToken(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
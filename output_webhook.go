@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+func init() {
+	registerOutput("webhook", newWebhookOutput)
+}
+
+// webhookOutput posts the result of each refresh as JSON to a URL
+type webhookOutput struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhookOutput(config json.RawMessage, client *http.Client) (output, error) {
+	decoded := struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Timeout int64             `json:"timeout"`
+	}{
+		Timeout: 10,
+	}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	return &webhookOutput{
+		url:     decoded.URL,
+		headers: decoded.Headers,
+		client: &http.Client{
+			Transport: client.Transport,
+			Timeout:   time.Second * time.Duration(decoded.Timeout),
+		},
+	}, nil
+}
+
+func (h *webhookOutput) send(event *refreshEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Could not encode the result of", event.Path, err)
+		return
+	}
+	go func() {
+		if err := h.post(body); err != nil {
+			log.Println("The webhook", h.url, "failed for", event.Path, err)
+		}
+	}()
+}
+
+func (h *webhookOutput) post(body []byte) error {
+	req, err := http.NewRequest("POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Got HTTP %d %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package waze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AddressFilter selects a candidate among the ones Waze returns for an address
+type AddressFilter struct {
+	Country     string
+	BoundingBox []float64
+	Index       int
+	Contains    string
+}
+
+func (f *AddressFilter) match(item *coordResponse) bool {
+	if item.Name == "" {
+		return false
+	}
+	if f.Country != "" && !strings.EqualFold(f.Country, item.CountryName) {
+		return false
+	}
+	if len(f.BoundingBox) == 4 {
+		if item.Location.Lon < f.BoundingBox[0] || item.Location.Lat < f.BoundingBox[1] ||
+			item.Location.Lon > f.BoundingBox[2] || item.Location.Lat > f.BoundingBox[3] {
+			return false
+		}
+	}
+	if f.Contains != "" && !strings.Contains(strings.ToLower(item.Name), strings.ToLower(f.Contains)) {
+		return false
+	}
+	return true
+}
+
+// AddressToQuery looks for an address and returns its coordinates as expected
+// by Parameters
+func AddressToQuery(address string, filter AddressFilter, language string, region Region, client *Client) (string, error) {
+	return AddressToQueryContext(context.Background(), address, filter, language, region, client)
+}
+
+// AddressToQueryContext is AddressToQuery giving up when the context is done
+func AddressToQueryContext(ctx context.Context, address string, filter AddressFilter, language string, region Region, client *Client) (string, error) {
+	client.log("Look for address", address)
+	param := url.Values{}
+	param.Set("q", address)
+	if language != "" {
+		param.Set("lang", language)
+	}
+	param.Set("lat", "0")
+	param.Set("lon", "0")
+
+	u := url.URL{
+		Scheme:   wazeScheme,
+		Host:     wazeHost,
+		Path:     coordServers[region],
+		RawQuery: param.Encode(),
+	}
+	client.debug("Call", u.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := do(client.HTTP, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	decodedResponse := []coordResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decodedResponse); err != nil {
+		return "", err
+	}
+	index := filter.Index
+	for i := range decodedResponse {
+		item := &decodedResponse[i]
+		if !filter.match(item) {
+			client.debug("Skip candidate", item.Name, item.CountryName, item.Location.Lon, item.Location.Lat)
+			continue
+		}
+		if index > 0 {
+			client.debug("Skip candidate", item.Name, item.CountryName, item.Location.Lon, item.Location.Lat)
+			index--
+			continue
+		}
+		client.log("Select candidate", item.Name, item.CountryName, item.Location.Lon, item.Location.Lat)
+		return CoordinatesToQuery(item.Location.Lon, item.Location.Lat), nil
+	}
+
+	if len(decodedResponse) > 0 {
+		return "", fmt.Errorf("%w, no candidate out of %d matches the selection rules: %s", ErrAddressNotFound, len(decodedResponse), address)
+	}
+	return "", fmt.Errorf("%w: %s", ErrAddressNotFound, address)
+}
+
+// CoordinatesToQuery returns the coordinates as expected by Parameters
+func CoordinatesToQuery(lon float64, lat float64) string {
+	return fmt.Sprintf("x:%f y:%f", lon, lat)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// coordResponse
+////////////////////////////////////////////////////////////////////////////////
+
+type coordResponse struct {
+	Name        string        `json:"name"`
+	CountryName string        `json:"countryName"`
+	Location    coordLocation `json:"location"`
+}
+
+type coordLocation struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
@@ -0,0 +1,105 @@
+package waze
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddressToQueryContext(t *testing.T) {
+	const candidates = `[
+		{"name": "Paris, France", "countryName": "France", "location": {"lon": 2.35, "lat": 48.85}},
+		{"name": "Paris, Texas", "countryName": "United States", "location": {"lon": -95.55, "lat": 33.66}},
+		{"name": "Paris Hilton Hotel", "countryName": "United States", "location": {"lon": -115.17, "lat": 36.11}},
+		{"name": "", "countryName": "France", "location": {"lon": 0, "lat": 0}}
+	]`
+	tests := []struct {
+		name     string
+		response string
+		filter   AddressFilter
+		expected string
+		notFound bool
+	}{
+		{
+			name:     "first",
+			response: candidates,
+			expected: "x:2.350000 y:48.850000",
+		},
+		{
+			name:     "country",
+			response: candidates,
+			filter:   AddressFilter{Country: "united states"},
+			expected: "x:-95.550000 y:33.660000",
+		},
+		{
+			name:     "country and index",
+			response: candidates,
+			filter:   AddressFilter{Country: "United States", Index: 1},
+			expected: "x:-115.170000 y:36.110000",
+		},
+		{
+			name:     "bounding box",
+			response: candidates,
+			filter:   AddressFilter{BoundingBox: []float64{-120, 30, -100, 40}},
+			expected: "x:-115.170000 y:36.110000",
+		},
+		{
+			name:     "contains",
+			response: candidates,
+			filter:   AddressFilter{Contains: "texas"},
+			expected: "x:-95.550000 y:33.660000",
+		},
+		{
+			name:     "no match",
+			response: candidates,
+			filter:   AddressFilter{Country: "Italy"},
+			notFound: true,
+		},
+		{
+			name:     "index too high",
+			response: candidates,
+			filter:   AddressFilter{Index: 3},
+			notFound: true,
+		},
+		{
+			name:     "no candidate",
+			response: `[]`,
+			notFound: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/SearchServer/mozi" || r.URL.Query().Get("q") != "Paris" || r.URL.Query().Get("lang") != "fr" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			got, err := AddressToQueryContext(context.Background(), "Paris", test.filter, "fr", US, testClient(server))
+			if test.notFound {
+				if !errors.Is(err, ErrAddressNotFound) {
+					t.Errorf("got %q %v, expected ErrAddressNotFound", got, err)
+				}
+			} else if err != nil || got != test.expected {
+				t.Errorf("got %q %v, expected %q", got, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestAddressToQueryContextHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := AddressToQueryContext(context.Background(), "Paris", AddressFilter{}, "", ROW, testClient(server))
+	var httpError *HTTPError
+	if !errors.As(err, &httpError) || !httpError.Overloaded() {
+		t.Errorf("got %v, expected an overloaded HTTPError", err)
+	}
+}
@@ -0,0 +1,70 @@
+package waze
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// do sends a request to Waze API. gzip is always asked, even if the transport
+// does not do it, and the body of the response is decompressed
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set("Referer", wazeReferer)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = &gzipBody{Reader: reader, body: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return resp, nil
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g *gzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// Ping checks that the geocoding and routing servers of the region can be
+// reached and do not block the client
+func Ping(ctx context.Context, region Region, client *Client) error {
+	for _, path := range []string{coordServers[region], routingServers[region]} {
+		u := url.URL{Scheme: wazeScheme, Host: wazeHost, Path: path}
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		client.debug("Call", u.String())
+		resp, err := do(client.HTTP, req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// the request has no parameter, so only the errors of the server or the
+		// ones blocking the client matter
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return fmt.Errorf("%s: %w", u.String(), &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status})
+		}
+	}
+	return nil
+}
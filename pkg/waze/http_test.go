@@ -0,0 +1,174 @@
+package waze
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// closeBody records if the body of the response has been closed
+type closeBody struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeBody) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		encoding   string
+		body       []byte
+		expected   string
+		err        bool
+	}{
+		{
+			name:       "gzip",
+			statusCode: http.StatusOK,
+			encoding:   "gzip",
+			body:       gzipped(t, `{"response": {}}`),
+			expected:   `{"response": {}}`,
+		},
+		{
+			name:       "plain",
+			statusCode: http.StatusOK,
+			body:       []byte(`{"response": {}}`),
+			expected:   `{"response": {}}`,
+		},
+		{
+			name:       "corrupt gzip",
+			statusCode: http.StatusOK,
+			encoding:   "gzip",
+			body:       []byte("not gzip"),
+			err:        true,
+		},
+		{
+			name:       "gzip error",
+			statusCode: http.StatusServiceUnavailable,
+			encoding:   "GZIP",
+			body:       gzipped(t, "overloaded"),
+			expected:   "overloaded",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept-Encoding") != "gzip" || r.Header.Get("Referer") != wazeReferer {
+					t.Errorf("unexpected headers %v", r.Header)
+				}
+				if test.encoding != "" {
+					w.Header().Set("Content-Encoding", test.encoding)
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(test.body)))
+				w.WriteHeader(test.statusCode)
+				w.Write(test.body)
+			}))
+			defer server.Close()
+			var body *closeBody
+			client := &http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					resp, err := server.Client().Transport.RoundTrip(req)
+					if err == nil {
+						body = &closeBody{ReadCloser: resp.Body}
+						resp.Body = body
+					}
+					return resp, err
+				}),
+			}
+			req, _ := http.NewRequest("GET", server.URL, nil)
+
+			resp, err := do(client, req)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !body.closed {
+					t.Error("the body is not closed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.statusCode {
+				t.Errorf("got HTTP %d, expected %d", resp.StatusCode, test.statusCode)
+			}
+			if test.encoding != "" {
+				if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 || !resp.Uncompressed {
+					t.Errorf("the response still looks compressed: %v %d", resp.Header, resp.ContentLength)
+				}
+			}
+			content, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(content)); got != test.expected {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !body.closed {
+				t.Error("the body is not closed")
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		err        bool
+	}{
+		{name: "missing parameters", statusCode: http.StatusBadRequest},
+		{name: "ok", statusCode: http.StatusOK},
+		{name: "blocked", statusCode: http.StatusForbidden, err: true},
+		{name: "overloaded", statusCode: http.StatusServiceUnavailable, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				w.WriteHeader(test.statusCode)
+			}))
+			defer server.Close()
+
+			err := Ping(context.Background(), IL, testClient(server))
+			if (err != nil) != test.err {
+				t.Errorf("got error %v", err)
+			}
+			expected := []string{"/il-SearchServer/mozi", "/il-RoutingManager/routingRequest"}
+			if test.err {
+				expected = expected[:1]
+			}
+			if !reflect.DeepEqual(paths, expected) {
+				t.Errorf("got %v, expected %v", paths, expected)
+			}
+		})
+	}
+}
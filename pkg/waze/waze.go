@@ -0,0 +1,462 @@
+// Package waze is a client of the Waze routing and geocoding APIs
+package waze
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameters of a route
+type Parameters struct {
+	FromCoordinates       string
+	ToCoordinates         string
+	Region                Region
+	Vehicle               Vehicle
+	AvoidToll             bool
+	AvoidSubscriptionRoad bool
+	AvoidFerry            bool
+	AvoidUnpaved          bool
+	DepartureOffset       time.Duration
+	// Routes is the number of routes requested, 1 if not set. The first one
+	// is the best one and the other ones are the alternatives
+	Routes int
+	// ExtraOptions are added to the routing options, for instance
+	// {"AVOID_DANGEROUS_TURNS": "t"}, and ExtraParams to the query string
+	ExtraOptions map[string]string
+	ExtraParams  map[string]string
+}
+
+// HTTPError is returned when Waze API answers with an HTTP error
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("Got HTTP %d %s", e.StatusCode, e.Status)
+}
+
+// Overloaded is true if Waze API asks to slow down
+func (e *HTTPError) Overloaded() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client calls Waze API with an HTTP client
+type Client struct {
+	HTTP *http.Client
+	// Log logs the requests and the selected addresses, and Debug logs each
+	// call. Nothing is logged if they are nil
+	Log   func(v ...interface{})
+	Debug func(v ...interface{})
+}
+
+func (c *Client) log(v ...interface{}) {
+	if c.Log != nil {
+		c.Log(v...)
+	}
+}
+
+func (c *Client) debug(v ...interface{}) {
+	if c.Debug != nil {
+		c.Debug(v...)
+	}
+}
+
+// Request is a routing request, which may be called several times
+type Request struct {
+	client     *Client
+	routingURL string
+	// SchemaWarning is called for each unexpected change of the structure of
+	// the responses, if not nil
+	SchemaWarning func(kind string)
+}
+
+// Result is a route
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Distance int
+	// Toll is true if the route uses a toll road
+	Toll bool
+	// Closures is the number of closed stretches of the route, made of one or
+	// several consecutive closed segments
+	Closures int
+	Segments []Segment
+}
+
+// Segment is a part of a route
+type Segment struct {
+	Street    string
+	Lon       float64
+	Lat       float64
+	Length    int
+	CrossTime time.Duration
+	Toll      bool
+	Closed    bool
+}
+
+const (
+	wazeScheme = "https"
+	wazeHost   = "www.waze.com"
+
+	wazeReferer = wazeScheme + "://" + wazeHost + "/"
+)
+
+var (
+	// ErrAddressNotFound is returned when no candidate matches an address
+	ErrAddressNotFound = errors.New("Address not found")
+	// ErrNoRoute is returned when Waze answers without any route
+	ErrNoRoute = errors.New("No route in the response")
+
+	coordServers = map[Region]string{
+		US:  "SearchServer/mozi",
+		IL:  "il-SearchServer/mozi",
+		ROW: "row-SearchServer/mozi",
+	}
+	routingServers = map[Region]string{
+		US:  "RoutingManager/routingRequest",
+		IL:  "il-RoutingManager/routingRequest",
+		ROW: "row-RoutingManager/routingRequest",
+	}
+)
+
+// NewRequest creates a routing request
+func NewRequest(wazeParam Parameters, client *Client) (*Request, error) {
+	param := url.Values{}
+	if wazeParam.Vehicle != Regular {
+		param.Set("vehicleType", wazeParam.Vehicle.String())
+	}
+	options := []string{"AVOID_TRAILS:f"}
+	if wazeParam.AvoidUnpaved {
+		options[0] = "AVOID_TRAILS:t"
+	}
+	if wazeParam.AvoidToll {
+		options = append(options, "AVOID_TOLL_ROADS:t")
+	}
+	if wazeParam.AvoidFerry {
+		options = append(options, "AVOID_FERRIES:t")
+	}
+	extraOptions := []string{}
+	for name, value := range wazeParam.ExtraOptions {
+		extraOptions = append(extraOptions, name+":"+value)
+	}
+	sort.Strings(extraOptions)
+	options = append(options, extraOptions...)
+	param.Set("options", strings.Join(options, ","))
+	if !wazeParam.AvoidSubscriptionRoad {
+		param.Set("subscription", "*")
+	}
+
+	param.Set("from", wazeParam.FromCoordinates)
+	param.Set("to", wazeParam.ToCoordinates)
+	param.Set("at", strconv.FormatInt(int64(wazeParam.DepartureOffset/time.Minute), 10))
+	param.Set("returnJSON", "true")
+	param.Set("timeout", "60000")
+	routes := wazeParam.Routes
+	if routes < 1 {
+		routes = 1
+	}
+	param.Set("nPaths", strconv.Itoa(routes))
+	for name, value := range wazeParam.ExtraParams {
+		param.Set(name, value)
+	}
+
+	u := url.URL{
+		Scheme:   wazeScheme,
+		Host:     wazeHost,
+		Path:     routingServers[wazeParam.Region],
+		RawQuery: param.Encode(),
+	}
+
+	client.log("Result query", u.String())
+	return &Request{
+		client:     client,
+		routingURL: u.String(),
+	}, nil
+}
+
+func decodeRoutingResponse(w *routingInnerResponse) Result {
+	sumLength := 0
+	toll := false
+	closures := 0
+	segments := []Segment{}
+	for _, segment := range w.Results {
+		decoded := Segment{
+			Lon:       segment.Path.X,
+			Lat:       segment.Path.Y,
+			CrossTime: time.Duration(segment.CrossTime) * time.Second,
+			Toll:      segment.IsToll,
+			Closed:    segment.IsClosed,
+		}
+		if segment.Street != nil && *segment.Street >= 0 && *segment.Street < len(w.StreetNames) {
+			decoded.Street = w.StreetNames[*segment.Street]
+		}
+		if segment.Length != nil {
+			sumLength += *segment.Length
+			decoded.Length = *segment.Length
+		}
+		toll = toll || segment.IsToll
+		if segment.IsClosed && (len(segments) == 0 || !segments[len(segments)-1].Closed) {
+			closures++
+		}
+		segments = append(segments, decoded)
+	}
+	totalRouteTime := 0
+	if w.TotalRouteTime != nil {
+		totalRouteTime = *w.TotalRouteTime
+	}
+	return Result{
+		Name:     w.RouteName,
+		Duration: time.Duration(totalRouteTime) * time.Second,
+		Distance: sumLength,
+		Toll:     toll,
+		Closures: closures,
+		Segments: segments,
+	}
+}
+
+func (w *Request) schemaWarning(kind string) {
+	w.client.log("Unexpected Waze response:", kind, w.routingURL)
+	if w.SchemaWarning != nil {
+		w.SchemaWarning(kind)
+	}
+}
+
+// Call calls Waze API
+func (w *Request) Call() ([]Result, error) {
+	return w.CallContext(context.Background())
+}
+
+// CallBefore calls Waze API and gives up at the deadline, unless it is zero
+func (w *Request) CallBefore(deadline time.Time) ([]Result, error) {
+	if deadline.IsZero() {
+		return w.Call()
+	}
+	if time.Until(deadline) <= 0 {
+		return nil, errors.New("Deadline exceeded")
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	return w.CallContext(ctx)
+}
+
+// CallContext calls Waze API and gives up when the context is done
+func (w *Request) CallContext(ctx context.Context) ([]Result, error) {
+	w.client.debug("Call", w.routingURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", w.routingURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(w.client.HTTP, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedResponse := routingResponse{}
+	if err := json.Unmarshal(body, &decodedResponse); err != nil {
+		w.schemaWarning("decode_error")
+		return nil, err
+	}
+	for _, kind := range decodedResponse.schemaWarnings() {
+		w.schemaWarning(kind)
+	}
+
+	// the responses without any segment are not routes
+	var result []Result
+	if decodedResponse.Response != nil && len(decodedResponse.Response.Results) > 0 {
+		result = append(result, decodeRoutingResponse(decodedResponse.Response))
+	}
+	for _, resp := range decodedResponse.Alternatives {
+		if len(resp.Response.Results) > 0 {
+			result = append(result, decodeRoutingResponse(&resp.Response))
+		}
+	}
+	if len(result) == 0 {
+		w.client.debug("No route in", string(body))
+		return nil, ErrNoRoute
+	}
+
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Region
+////////////////////////////////////////////////////////////////////////////////
+
+type Region int
+
+const (
+	ROW Region = iota
+	US
+	IL
+)
+
+var marshalRegionMap = map[Region]string{
+	US:  "US",
+	IL:  "IL",
+	ROW: "ROW",
+}
+
+var unmarshalRegionMap = map[string]Region{
+	"US":  US,
+	"IL":  IL,
+	"ROW": ROW,
+}
+
+func (s Region) String() string {
+	return marshalRegionMap[s]
+}
+
+func (s Region) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Region) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	if val, found := unmarshalRegionMap[strings.ToUpper(j)]; found {
+		*s = val
+		return nil
+	}
+	return errors.New("Cannot unmarshal " + j + " as region")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Vehicle
+////////////////////////////////////////////////////////////////////////////////
+
+// Vehicle is the vehicleType sent to Waze. The types which are not listed
+// below are sent as is, so new types do not require a code change
+type Vehicle string
+
+const (
+	Regular    Vehicle = ""
+	Private    Vehicle = "PRIVATE"
+	Taxi       Vehicle = "TAXI"
+	Motorcycle Vehicle = "MOTORCYCLE"
+)
+
+var knownVehicles = map[Vehicle]bool{
+	Regular:    true,
+	Private:    true,
+	Taxi:       true,
+	Motorcycle: true,
+}
+
+// Known is false for the types which are sent as is to Waze
+func (s Vehicle) Known() bool {
+	return knownVehicles[s]
+}
+
+func (s Vehicle) String() string {
+	return string(s)
+}
+
+func (s Vehicle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Vehicle) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	val := Vehicle(strings.ToUpper(strings.TrimSpace(j)))
+	for _, c := range val {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return errors.New("Cannot unmarshal " + j + " as vehicle")
+		}
+	}
+	*s = val
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// routingResponse
+////////////////////////////////////////////////////////////////////////////////
+
+type routingResponse struct {
+	Response     *routingInnerResponse `json:"response"`
+	Alternatives []routingAlternative  `json:"alternatives"`
+}
+
+type routingAlternative struct {
+	Response routingInnerResponse `json:"response"`
+}
+
+type routingInnerResponse struct {
+	Results        []routingResult `json:"results"`
+	TotalRouteTime *int            `json:"totalRouteTime"`
+	RouteName      string          `json:"routeName"`
+	StreetNames    []string        `json:"streetNames"`
+}
+
+type routingResult struct {
+	Path      routingPath `json:"path"`
+	Street    *int        `json:"street"`
+	Length    *int        `json:"length"`
+	CrossTime int         `json:"crossTime"`
+	IsToll    bool        `json:"isToll"`
+	IsClosed  bool        `json:"isClosed"`
+}
+
+type routingPath struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// schemaWarnings lists the fields which are missing or empty in the response
+func (w *routingResponse) schemaWarnings() []string {
+	if w.Response == nil && len(w.Alternatives) == 0 {
+		return []string{"missing_response"}
+	}
+	responses := []*routingInnerResponse{}
+	if w.Response != nil {
+		responses = append(responses, w.Response)
+	}
+	for i := range w.Alternatives {
+		responses = append(responses, &w.Alternatives[i].Response)
+	}
+
+	warnings := map[string]bool{}
+	for _, response := range responses {
+		if response.TotalRouteTime == nil {
+			warnings["missing_total_route_time"] = true
+		}
+		if len(response.Results) == 0 {
+			warnings["empty_results"] = true
+		}
+		for _, result := range response.Results {
+			if result.Length == nil {
+				warnings["missing_length"] = true
+			}
+		}
+	}
+	result := []string{}
+	for warning := range warnings {
+		result = append(result, warning)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,265 @@
+package waze
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testClient sends all the requests to the test server instead of Waze
+func testClient(server *httptest.Server) *Client {
+	target, _ := url.Parse(server.URL)
+	return &Client{HTTP: &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters Parameters
+		path       string
+		query      map[string]string
+	}{
+		{
+			name: "default",
+			parameters: Parameters{
+				FromCoordinates: "x:2.350000 y:48.850000",
+				ToCoordinates:   "x:2.294437 y:48.858312",
+			},
+			path: "/row-RoutingManager/routingRequest",
+			query: map[string]string{
+				"from":         "x:2.350000 y:48.850000",
+				"to":           "x:2.294437 y:48.858312",
+				"at":           "0",
+				"nPaths":       "1",
+				"options":      "AVOID_TRAILS:f",
+				"subscription": "*",
+				"returnJSON":   "true",
+				"timeout":      "60000",
+				"vehicleType":  "",
+			},
+		},
+		{
+			name: "options",
+			parameters: Parameters{
+				Region:                US,
+				Vehicle:               Taxi,
+				AvoidToll:             true,
+				AvoidSubscriptionRoad: true,
+				AvoidFerry:            true,
+				AvoidUnpaved:          true,
+				DepartureOffset:       90 * time.Minute,
+				Routes:                3,
+				ExtraOptions:          map[string]string{"B": "t", "A": "f"},
+				ExtraParams:           map[string]string{"returnGeometries": "true"},
+			},
+			path: "/RoutingManager/routingRequest",
+			query: map[string]string{
+				"at":               "90",
+				"nPaths":           "3",
+				"options":          "AVOID_TRAILS:t,AVOID_TOLL_ROADS:t,AVOID_FERRIES:t,A:f,B:t",
+				"subscription":     "",
+				"vehicleType":      "TAXI",
+				"returnGeometries": "true",
+			},
+		},
+		{
+			name:       "israel",
+			parameters: Parameters{Region: IL},
+			path:       "/il-RoutingManager/routingRequest",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := NewRequest(test.parameters, &Client{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(request.routingURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != wazeHost || u.Path != test.path {
+				t.Errorf("got %s%s, expected %s%s", u.Host, u.Path, wazeHost, test.path)
+			}
+			query := u.Query()
+			for key, expected := range test.query {
+				if got := query.Get(key); got != expected {
+					t.Errorf("%s: got %q, expected %q", key, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRoutingResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected Result
+	}{
+		{
+			name: "segments",
+			response: `{"routeName": "A6", "totalRouteTime": 600, "streetNames": ["Rue A", "A6"],
+				"results": [
+					{"path": {"x": 2.1, "y": 48.1}, "street": 0, "length": 100, "crossTime": 10},
+					{"path": {"x": 2.2, "y": 48.2}, "street": 1, "length": 200, "crossTime": 20, "isToll": true}
+				]}`,
+			expected: Result{
+				Name:     "A6",
+				Duration: 10 * time.Minute,
+				Distance: 300,
+				Toll:     true,
+				Segments: []Segment{
+					{Street: "Rue A", Lon: 2.1, Lat: 48.1, Length: 100, CrossTime: 10 * time.Second},
+					{Street: "A6", Lon: 2.2, Lat: 48.2, Length: 200, CrossTime: 20 * time.Second, Toll: true},
+				},
+			},
+		},
+		{
+			name: "closures",
+			response: `{"totalRouteTime": 60, "results": [
+				{"length": 1, "isClosed": true},
+				{"length": 1, "isClosed": true},
+				{"length": 1},
+				{"length": 1, "isClosed": true}
+			]}`,
+			expected: Result{
+				Duration: time.Minute,
+				Distance: 4,
+				Closures: 2,
+				Segments: []Segment{
+					{Length: 1, Closed: true},
+					{Length: 1, Closed: true},
+					{Length: 1},
+					{Length: 1, Closed: true},
+				},
+			},
+		},
+		{
+			name: "unknown street",
+			response: `{"streetNames": ["Rue A"], "results": [
+				{"street": 1},
+				{"street": -1},
+				{}
+			]}`,
+			expected: Result{
+				Segments: []Segment{{}, {}, {}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := routingInnerResponse{}
+			if err := json.Unmarshal([]byte(test.response), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeRoutingResponse(&response); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %+v, expected %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSchemaWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected []string
+	}{
+		{
+			name:     "valid",
+			response: `{"response": {"totalRouteTime": 60, "results": [{"length": 1}]}}`,
+			expected: []string{},
+		},
+		{
+			name:     "missing response",
+			response: `{}`,
+			expected: []string{"missing_response"},
+		},
+		{
+			name:     "missing fields",
+			response: `{"response": {"results": [{"length": 1}, {}]}}`,
+			expected: []string{"missing_length", "missing_total_route_time"},
+		},
+		{
+			name:     "alternatives",
+			response: `{"alternatives": [{"response": {"totalRouteTime": 60, "results": [{"length": 1}]}}, {"response": {"totalRouteTime": 60}}]}`,
+			expected: []string{"empty_results"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := routingResponse{}
+			if err := json.Unmarshal([]byte(test.response), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got := response.schemaWarnings(); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRegion(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Region
+		err      bool
+	}{
+		{value: `"US"`, expected: US},
+		{value: `"il"`, expected: IL},
+		{value: `"row"`, expected: ROW},
+		{value: `"EU"`, err: true},
+		{value: `1`, err: true},
+	}
+	for _, test := range tests {
+		var got Region
+		err := json.Unmarshal([]byte(test.value), &got)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.value, err)
+		} else if !test.err && got != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.value, got, test.expected)
+		}
+	}
+}
+
+func TestUnmarshalVehicle(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Vehicle
+		known    bool
+		err      bool
+	}{
+		{value: `""`, expected: Regular, known: true},
+		{value: `"taxi"`, expected: Taxi, known: true},
+		{value: `" Motorcycle "`, expected: Motorcycle, known: true},
+		{value: `"electric_car"`, expected: "ELECTRIC_CAR"},
+		{value: `"TAXI&x=1"`, err: true},
+		{value: `1`, err: true},
+	}
+	for _, test := range tests {
+		var got Vehicle
+		err := json.Unmarshal([]byte(test.value), &got)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.value, err)
+		} else if !test.err && (got != test.expected || got.Known() != test.known) {
+			t.Errorf("%s: got %q known %v, expected %q known %v", test.value, got, got.Known(), test.expected, test.known)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Open Location Code (Plus Code) decoding, see
+// https://github.com/google/open-location-code/blob/main/docs/specification.md
+
+const (
+	plusCodeAlphabet     = "23456789CFGHJMPQRVWX"
+	plusCodeSeparator    = '+'
+	plusCodeSeparatorPos = 8
+	plusCodePadding      = '0'
+	plusCodePairLength   = 10
+	plusCodeGridColumns  = 4
+	plusCodeGridRows     = 5
+)
+
+// isPlusCode returns true if the string is a full Plus Code such as
+// 8FW4V75V+8Q. Short codes need a reference location and are not supported
+func isPlusCode(code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if strings.IndexByte(code, plusCodeSeparator) != plusCodeSeparatorPos ||
+		strings.Count(code, string(plusCodeSeparator)) != 1 ||
+		len(code) == plusCodeSeparatorPos+2 {
+		return false
+	}
+	paddingStart := -1
+	for i, c := range code {
+		switch {
+		case i == plusCodeSeparatorPos:
+		case c == plusCodePadding:
+			if i > plusCodeSeparatorPos {
+				return false
+			}
+			if paddingStart < 0 {
+				paddingStart = i
+			}
+		case paddingStart >= 0 || strings.IndexRune(plusCodeAlphabet, c) < 0:
+			return false
+		}
+	}
+	// the padding is made of pairs and cannot be followed by other digits
+	if paddingStart >= 0 && (paddingStart < 2 || paddingStart%2 == 1 || len(code) > plusCodeSeparatorPos+1) {
+		return false
+	}
+	// the first two characters encode the latitude (< 180) and longitude (< 360)
+	return strings.IndexByte(plusCodeAlphabet, code[0])*20 < 180 &&
+		strings.IndexByte(plusCodeAlphabet, code[1])*20 < 360
+}
+
+// decodePlusCode returns the latitude and longitude of the center of the area
+// of a full Plus Code
+func decodePlusCode(code string) (float64, float64, error) {
+	if !isPlusCode(code) {
+		return 0, 0, errors.New("Invalid full Plus Code: " + code)
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	code = strings.Replace(code, string(plusCodeSeparator), "", 1)
+	code = strings.TrimRight(code, string(plusCodePadding))
+
+	lat, lon := -90., -180.
+	latResolution, lonResolution := 400., 400.
+	for i := 0; i < len(code); i++ {
+		value := float64(strings.IndexByte(plusCodeAlphabet, code[i]))
+		if i < plusCodePairLength {
+			if i%2 == 0 {
+				latResolution /= 20
+				lat += value * latResolution
+			} else {
+				lonResolution /= 20
+				lon += value * lonResolution
+			}
+		} else {
+			latResolution /= plusCodeGridRows
+			lonResolution /= plusCodeGridColumns
+			row := int(value) / plusCodeGridColumns
+			column := int(value) % plusCodeGridColumns
+			lat += float64(row) * latResolution
+			lon += float64(column) * lonResolution
+		}
+	}
+
+	lat += latResolution / 2
+	lon += lonResolution / 2
+	if lat > 90 {
+		lat = 90
+	}
+	return lat, lon, nil
+}
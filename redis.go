@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal client of the Redis protocol (RESP), see
+// https://redis.io/docs/reference/protocol-spec/
+type redisClient struct {
+	mutex    sync.Mutex
+	address  string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+const (
+	redisTimeout = 5 * time.Second
+)
+
+// newRedisClient parses an URL such as redis://:password@host:6379/0
+func newRedisClient(redisURL string) (*redisClient, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, errors.New("The Redis URL must be redis://[:password@]host[:port][/db]: " + redisURL)
+	}
+	client := &redisClient{
+		address: u.Host,
+	}
+	if u.Port() == "" {
+		client.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		client.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if client.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.New("Invalid Redis database: " + db)
+		}
+	}
+	return client, nil
+}
+
+// connect opens the connection if needed. The mutex must be held
+func (r *redisClient) connect() error {
+	if r.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", r.address, redisTimeout)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+	if r.password != "" {
+		if _, err := r.command("AUTH", r.password); err != nil {
+			r.close()
+			return err
+		}
+	}
+	if r.db != 0 {
+		if _, err := r.command("SELECT", strconv.Itoa(r.db)); err != nil {
+			r.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// close drops the connection. The mutex must be held
+func (r *redisClient) close() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+		r.reader = nil
+	}
+}
+
+// Do sends a command and returns its reply: nil, string, int64 or []interface{}
+// whose elements may also be redisError
+func (r *redisClient) Do(args ...string) (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	reply, err := r.command(args...)
+	if err != nil {
+		if _, isRedisError := err.(redisError); !isRedisError {
+			// the connection is in an unknown state
+			r.close()
+		}
+	}
+	return reply, err
+}
+
+// command sends a command on the connection. The mutex must be held
+func (r *redisClient) command(args ...string) (interface{}, error) {
+	r.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&builder, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := r.conn.Write([]byte(builder.String())); err != nil {
+		return nil, err
+	}
+	return r.readReply()
+}
+
+// readReply decodes one reply. The mutex must be held
+func (r *redisClient) readReply() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("Invalid Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		buffer := make([]byte, length+2)
+		if _, err := io.ReadFull(r.reader, buffer); err != nil {
+			return nil, err
+		}
+		return string(buffer[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		result := make([]interface{}, length)
+		for i := range result {
+			// an error in an array, such as the reply of EXEC, is an element
+			// so that the rest of the array is still read
+			if result[i], err = r.readReply(); err != nil {
+				redisErr, isRedisError := err.(redisError)
+				if !isRedisError {
+					return nil, err
+				}
+				result[i] = redisErr
+			}
+		}
+		return result, nil
+	}
+	return nil, errors.New("Invalid Redis reply: " + line)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis error: " + string(e)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedisReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		expected interface{}
+		err      string
+	}{
+		{name: "simple string", reply: "+OK\r\n", expected: "OK"},
+		{name: "integer", reply: ":-42\r\n", expected: int64(-42)},
+		{name: "bulk string", reply: "$5\r\nhe\r\no\r\n", expected: "he\r\no"},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", expected: ""},
+		{name: "nil bulk string", reply: "$-1\r\n", expected: nil},
+		{name: "nil array", reply: "*-1\r\n", expected: nil},
+		{name: "array", reply: "*3\r\n$3\r\nfoo\r\n:1\r\n*1\r\n$-1\r\n", expected: []interface{}{"foo", int64(1), []interface{}{nil}}},
+		{name: "empty array", reply: "*0\r\n", expected: []interface{}{}},
+		{name: "error in array", reply: "*2\r\n-ERR wrong type\r\n+OK\r\n", expected: []interface{}{redisError("ERR wrong type"), "OK"}},
+		{name: "error", reply: "-WRONGPASS invalid password\r\n", err: "Redis error: WRONGPASS invalid password"},
+		{name: "truncated bulk string", reply: "$10\r\nfoo\r\n", err: "unexpected EOF"},
+		{name: "truncated array", reply: "*2\r\n:1\r\n", err: "EOF"},
+		{name: "invalid", reply: "?\r\n", err: "Invalid Redis reply: ?"},
+		{name: "empty", reply: "\r\n", err: "Invalid Redis reply"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &redisClient{reader: bufio.NewReader(strings.NewReader(test.reply))}
+			reply, err := r.readReply()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reply, test.expected) {
+				t.Errorf("got %#v, expected %#v", reply, test.expected)
+			}
+		})
+	}
+}
+
+// fakeRedis accepts connections and replies to each command with the
+// replies of the connection, closing it when there are no more replies
+func fakeRedis(t *testing.T, connections ...[]string) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	commands := make(chan []string, 16)
+	go func() {
+		for _, replies := range connections {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for _, reply := range replies {
+				command, err := readRedisCommand(reader)
+				if err != nil {
+					break
+				}
+				commands <- command
+				conn.Write([]byte(reply))
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	r := &redisClient{reader: reader}
+	reply, err := r.readReply()
+	if err != nil {
+		return nil, err
+	}
+	command := []string{}
+	for _, arg := range reply.([]interface{}) {
+		command = append(command, arg.(string))
+	}
+	return command, nil
+}
+
+func TestRedisClientReconnect(t *testing.T) {
+	address, commands := fakeRedis(t,
+		[]string{"+OK\r\n", "+OK\r\n", "$3\r\nbar\r\n"},
+		[]string{"+OK\r\n", "+OK\r\n", "-ERR unknown command\r\n", ":1\r\n"},
+	)
+	client, err := newRedisClient("redis://:secret@" + address + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(expected ...string) {
+		if command := <-commands; !reflect.DeepEqual(command, expected) {
+			t.Errorf("got command %v, expected %v", command, expected)
+		}
+	}
+
+	if reply, err := client.Do("GET", "foo"); err != nil || reply != "bar" {
+		t.Errorf("got %v %v, expected bar", reply, err)
+	}
+	expect("AUTH", "secret")
+	expect("SELECT", "2")
+	expect("GET", "foo")
+
+	// the server closes the connection before replying
+	if _, err := client.Do("GET", "foo"); err == nil {
+		t.Error("expected an I/O error")
+	}
+
+	// a Redis error does not drop the new connection
+	if _, err := client.Do("FOO"); err == nil || err.Error() != "Redis error: ERR unknown command" {
+		t.Errorf("got error %v, expected a Redis error", err)
+	}
+	expect("AUTH", "secret")
+	expect("SELECT", "2")
+	expect("FOO")
+	if reply, err := client.Do("INCR", "foo"); err != nil || reply != int64(1) {
+		t.Errorf("got %v %v, expected 1", reply, err)
+	}
+	expect("INCR", "foo")
+}
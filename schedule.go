@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// timeRange is a range of the day, in minutes since midnight. It wraps around
+// midnight if the end is before the start
+type timeRange struct {
+	start int
+	end   int
+}
+
+// parseTimeRange parses a range such as "07:00-09:00" or "22:00-06:00"
+func parseTimeRange(value string) (timeRange, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return timeRange{}, fmt.Errorf("Invalid time range %q, expecting HH:MM-HH:MM", value)
+	}
+	result := timeRange{}
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return timeRange{}, fmt.Errorf("Invalid time range %q, expecting HH:MM-HH:MM", value)
+		}
+		minutes := t.Hour()*60 + t.Minute()
+		if i == 0 {
+			result.start = minutes
+		} else {
+			result.end = minutes
+		}
+	}
+	return result, nil
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if r.start <= r.end {
+		return minutes >= r.start && minutes < r.end
+	}
+	return minutes >= r.start || minutes < r.end
+}
+
+// remaining returns the time until the end of the range, which must contain t
+func (r timeRange) remaining(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	end := midnight.Add(time.Duration(r.end) * time.Minute)
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.Sub(t)
+}
+
+// schedule tells when a path is monitored
+type schedule struct {
+	weekdaysOnly bool
+	holidays     map[string]bool // local dates, formatted with dateLayout
+	calendar     *calendar
+	event        *regexp.Regexp
+	blackouts    []timeRange
+}
+
+func newSchedule(path *Path, holidays []string, calendar *calendar) *schedule {
+	if !path.WeekdaysOnly && path.CalendarEvent == "" && len(path.Blackouts) == 0 {
+		return nil
+	}
+	s := &schedule{
+		weekdaysOnly: path.WeekdaysOnly,
+		holidays:     map[string]bool{},
+	}
+	for _, blackout := range path.Blackouts {
+		// already checked with the configuration
+		r, _ := parseTimeRange(blackout)
+		s.blackouts = append(s.blackouts, r)
+	}
+	if path.CalendarEvent != "" {
+		s.calendar = calendar
+		s.event = regexp.MustCompile(path.CalendarEvent)
+	}
+	for _, holiday := range holidays {
+		s.holidays[holiday] = true
+	}
+	return s
+}
+
+// active returns true if the path must be refreshed at the given time. A nil
+// schedule is always active
+func (s *schedule) active(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.weekdaysOnly {
+		// the public holidays are treated as weekends
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday || s.holidays[t.Format(dateLayout)] {
+			return false
+		}
+	}
+	for _, blackout := range s.blackouts {
+		if blackout.contains(t) {
+			return false
+		}
+	}
+	if s.calendar != nil && !s.calendar.ongoing(t, s.event) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+// selftestCommand checks that the Waze servers are reachable, geocodes the
+// addresses of the first path and routes it. It prints a report and exits
+// with 1 if one of the checks failed
+func selftestCommand(configFile string) {
+	jsonConfig := NewConfig(configFile)
+	initMetrics(jsonConfig.MetricNames)
+	client := &http.Client{
+		Timeout:   time.Second * time.Duration(jsonConfig.Timeout),
+		Transport: newTransport(jsonConfig),
+	}
+
+	failed := false
+	report := func(check string, begin time.Time, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL %s: %v\n", check, err)
+		} else {
+			fmt.Printf("PASS %s (%v)\n", check, time.Since(begin).Round(time.Millisecond))
+		}
+	}
+
+	// the checks are reported on the standard output, not in the logs
+	wazeClient := &waze.Client{HTTP: client}
+
+	begin := time.Now()
+	err := waze.Ping(stdcontext.Background(), jsonConfig.Region, wazeClient)
+	var httpError *waze.HTTPError
+	if errors.As(err, &httpError) {
+		err = fmt.Errorf("%w, Waze may be blocking this address", err)
+	}
+	report("connect to the Waze servers of region "+jsonConfig.Region.String(), begin, err)
+
+	var path *Path
+	names := []string{}
+	if len(jsonConfig.Paths) > 0 {
+		path = &jsonConfig.Paths[0]
+		names = append(names, path.From, path.To)
+	} else {
+		for name := range jsonConfig.Addresses {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) > 1 {
+			names = names[:1]
+		}
+	}
+	if len(names) == 0 {
+		report("geocode address", time.Now(), errors.New("no address configured"))
+	}
+
+	geocoders := newGeocoders(jsonConfig, client)
+	geocoders.wazeClient = wazeClient
+	coordinates := map[string]string{}
+	for _, name := range names {
+		begin := time.Now()
+		value, err := geocoders.resolve(jsonConfig.Addresses[name])
+		if err == nil {
+			err = validateCoordinates(value, jsonConfig.Region)
+		}
+		report(fmt.Sprintf("geocode address %s (%s)", name, jsonConfig.Addresses[name].String()), begin, err)
+		if err == nil {
+			coordinates[name] = value
+		}
+	}
+
+	if path != nil && coordinates[path.From] != "" && coordinates[path.To] != "" {
+		begin := time.Now()
+		var result []waze.Result
+		request, err := waze.NewRequest(newParameters(jsonConfig, path, coordinates[path.From], coordinates[path.To]), wazeClient)
+		if err == nil {
+			result, err = request.Call()
+		}
+		report("route path "+path.Name+" in region "+jsonConfig.Region.String(), begin, err)
+		if err == nil {
+			fmt.Printf("     %v, %d meters\n", result[0].Duration, result[0].Distance)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
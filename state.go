@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+// state is the runtime state of the exporter, saved by the snapshot command
+// and loaded by the restore command to move an instance without losing its
+// geocoded addresses, its last values and its history
+type state struct {
+	Time      time.Time                `json:"time"`
+	Addresses map[string]*stateAddress `json:"addresses"`
+	Paths     map[string]*statePath    `json:"paths"`
+}
+
+type stateAddress struct {
+	// Address is the configured address, so that it is geocoded again if it
+	// has changed
+	Address     string `json:"address"`
+	Coordinates string `json:"coordinates"`
+}
+
+type statePath struct {
+	FromCoordinates string          `json:"from_coordinates"`
+	ToCoordinates   string          `json:"to_coordinates"`
+	LastUpdate      time.Time       `json:"last_update"`
+	Results         []waze.Result   `json:"results,omitempty"`
+	Typical         *float64        `json:"typical,omitempty"`
+	History         []historySample `json:"history,omitempty"`
+}
+
+func (c *context) snapshot() *state {
+	result := &state{
+		Time:      time.Now(),
+		Addresses: c.addresses,
+		Paths:     map[string]*statePath{},
+	}
+	for _, metric := range c.wazeMetrics {
+		path := &statePath{
+			FromCoordinates: metric.wazeParameters.FromCoordinates,
+			ToCoordinates:   metric.wazeParameters.ToCoordinates,
+			LastUpdate:      metric.getLastUpdate(),
+		}
+		if results, ok := metric.lastResults.Load().([]waze.Result); ok {
+			path.Results = results
+		}
+		if typical, ok := metric.lastTypical.Load().(float64); ok {
+			path.Typical = &typical
+		}
+		if metric.history != nil {
+			path.History = metric.history.since(time.Time{})
+		}
+		result.Paths[metric.name] = path
+	}
+	return result
+}
+
+// restore loads the state of a path, unless its coordinates have changed
+func (w *wazeMetric) restore(path *statePath) {
+	if path.FromCoordinates != w.wazeParameters.FromCoordinates || path.ToCoordinates != w.wazeParameters.ToCoordinates {
+		log.Println("The coordinates of path", w.name, "have changed, its state is not restored")
+		return
+	}
+	if len(path.Results) > 0 {
+		w.update(path.LastUpdate, path.Results)
+	}
+	if path.Typical != nil && w.timeTravelTypical != nil {
+		w.lastTypical.Store(*path.Typical)
+		w.timeTravelTypical.Set(*path.Typical)
+	}
+	if w.history != nil {
+		w.history.restore(path.History)
+	}
+}
+
+// snapshotHandler serves the state of the exporter
+func (c *context) snapshotHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.snapshot())
+	})
+}
+
+func readState(filename string) *state {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	result := &state{}
+	if err := json.Unmarshal(content, result); err != nil {
+		log.Fatalln("Invalid state file", filename, err)
+	}
+	return result
+}
+
+// snapshotCommand gets the state of the exporter running with the given
+// configuration and writes it to a file
+func snapshotCommand(configFile string, stateFile string) {
+	content := getSnapshot(NewConfig(configFile))
+	if err := os.WriteFile(stateFile, content, 0644); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Snapshot written to", stateFile)
+}
+
+// getSnapshot gets the state of the exporter running with the given
+// configuration from its /-/snapshot endpoint
+func getSnapshot(jsonConfig *Config) []byte {
+	if jsonConfig.AdminToken == "" {
+		log.Fatalln("admin_token is required to get a snapshot")
+	}
+	listen := ""
+	for _, listener := range newListeners(jsonConfig) {
+		if listener.serves("/-/snapshot") {
+			listen = listener.listen
+			break
+		}
+	}
+	if listen == "" {
+		log.Fatalln("No listener serves /-/snapshot")
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	req, err := http.NewRequest("GET", "http://"+net.JoinHostPort(host, port)+"/-/snapshot", nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jsonConfig.AdminToken)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalln("Got HTTP", resp.Status, "from", req.URL)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return content
+}
@@ -0,0 +1,57 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log/syslog"
+	"net/url"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"KERN":     syslog.LOG_KERN,
+	"USER":     syslog.LOG_USER,
+	"MAIL":     syslog.LOG_MAIL,
+	"DAEMON":   syslog.LOG_DAEMON,
+	"AUTH":     syslog.LOG_AUTH,
+	"SYSLOG":   syslog.LOG_SYSLOG,
+	"LPR":      syslog.LOG_LPR,
+	"NEWS":     syslog.LOG_NEWS,
+	"UUCP":     syslog.LOG_UUCP,
+	"CRON":     syslog.LOG_CRON,
+	"AUTHPRIV": syslog.LOG_AUTHPRIV,
+	"FTP":      syslog.LOG_FTP,
+	"LOCAL0":   syslog.LOG_LOCAL0,
+	"LOCAL1":   syslog.LOG_LOCAL1,
+	"LOCAL2":   syslog.LOG_LOCAL2,
+	"LOCAL3":   syslog.LOG_LOCAL3,
+	"LOCAL4":   syslog.LOG_LOCAL4,
+	"LOCAL5":   syslog.LOG_LOCAL5,
+	"LOCAL6":   syslog.LOG_LOCAL6,
+	"LOCAL7":   syslog.LOG_LOCAL7,
+}
+
+// newSyslogWriter connects to the local syslog daemon if address is "local",
+// or to a remote one such as udp://nas:514 or tcp://nas:514
+func newSyslogWriter(address string, facility string, tag string) (io.Writer, error) {
+	priority, found := syslogFacilities[strings.ToUpper(facility)]
+	if !found {
+		return nil, errors.New("Unknown syslog facility: " + facility)
+	}
+	priority |= syslog.LOG_INFO
+
+	if address == "local" {
+		return syslog.New(priority, tag)
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, errors.New("The syslog address must be local, udp://host:port or tcp://host:port: " + address)
+	}
+	return syslog.Dial(u.Scheme, u.Host, priority, tag)
+}
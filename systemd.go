@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd notifications, see
+// https://www.freedesktop.org/software/systemd/man/sd_notify.html
+
+var (
+	sdWatchdogInterval = watchdogInterval()
+)
+
+// sdNotify sends a state such as READY=1 to systemd if it is the supervisor
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Println("Cannot notify systemd", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Println("Cannot notify systemd", err)
+	}
+}
+
+// watchdogInterval returns the maximum duration between two WATCHDOG=1
+// notifications, or 0 if the watchdog is disabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+func sdWatchdog() {
+	if sdWatchdogInterval > 0 {
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// sdWatchdogSleep sleeps while keeping the watchdog happy
+func sdWatchdogSleep(d time.Duration) {
+	for sdWatchdogInterval > 0 && d > sdWatchdogInterval/2 {
+		time.Sleep(sdWatchdogInterval / 2)
+		sdWatchdog()
+		d -= sdWatchdogInterval / 2
+	}
+	time.Sleep(d)
+}
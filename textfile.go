@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeTextfile writes the metrics of the paths atomically to a file for the
+// textfile collector of node_exporter. The metrics of the Go runtime are left
+// out as node_exporter already exposes its own. Without interval, the paths
+// are refreshed first, as for a scrape
+func (c *context) writeTextfile(filename string) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	return prometheus.WriteToTextfile(filename, registry)
+}
+
+// pollTextfile refreshes all the paths every interval and writes the file
+// after each refresh
+func (c *context) pollTextfile(filename string) {
+	lastRefresh := time.Now()
+	// not paced, so that the file is written as soon as possible
+	c.refresh(false)
+	for {
+		if err := c.writeTextfile(filename); err != nil {
+			log.Println("Could not write", filename, err)
+		}
+		sdNotify("READY=1")
+		sdWatchdogSleep(c.nextInterval(c.throttle.adjust()) - time.Since(lastRefresh))
+		lastRefresh = time.Now()
+		c.refresh(c.pacing)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+const throttleFactor = 2
+
+// throttle stretches the polling interval while Waze API answers with HTTP
+// 429 or 5xx, and shrinks it back once the calls succeed again
+type throttle struct {
+	minInterval time.Duration
+	maxInterval time.Duration
+	interval    time.Duration
+	overloaded  int32 // atomic, number of calls rejected since the last adjust
+	succeeded   int32 // atomic, number of successful calls since the last adjust
+	gauge       prometheus.Gauge
+}
+
+func newThrottle(interval time.Duration, maxInterval time.Duration, gauge prometheus.Gauge) *throttle {
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	gauge.Set(interval.Seconds())
+	return &throttle{
+		minInterval: interval,
+		maxInterval: maxInterval,
+		interval:    interval,
+		gauge:       gauge,
+	}
+}
+
+// observe records the result of a call to Waze API
+func (t *throttle) observe(err error) {
+	var httpErr *waze.HTTPError
+	if err == nil {
+		atomic.AddInt32(&t.succeeded, 1)
+	} else if errors.As(err, &httpErr) && httpErr.Overloaded() {
+		atomic.AddInt32(&t.overloaded, 1)
+	}
+}
+
+// current returns the interval until the next refresh
+func (t *throttle) current() time.Duration {
+	return t.interval
+}
+
+// adjust returns the interval until the next refresh depending on the calls
+// observed since the previous one
+func (t *throttle) adjust() time.Duration {
+	overloaded := atomic.SwapInt32(&t.overloaded, 0)
+	succeeded := atomic.SwapInt32(&t.succeeded, 0)
+	interval := t.interval
+	if overloaded > 0 {
+		interval *= throttleFactor
+		if interval > t.maxInterval {
+			interval = t.maxInterval
+		}
+	} else if succeeded > 0 {
+		interval /= throttleFactor
+		if interval < t.minInterval {
+			interval = t.minInterval
+		}
+	}
+	if interval != t.interval {
+		log.Println("Waze API got", overloaded, "rejected calls, the interval is now", interval)
+		t.interval = interval
+		t.gauge.Set(interval.Seconds())
+	}
+	return interval
+}
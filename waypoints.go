@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type waypoint struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// loadWaypoints reads the waypoints of a GPX or KML file, in order
+func loadWaypoints(filename string) ([]waypoint, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return decodeGPX(content)
+	case ".kml":
+		return decodeKML(content)
+	}
+	return nil, errors.New("Unknown waypoints file format (expecting .gpx or .kml): " + filename)
+}
+
+func decodeGPX(content []byte) ([]waypoint, error) {
+	decoded := gpx{}
+	if err := xml.Unmarshal(content, &decoded); err != nil {
+		return nil, err
+	}
+
+	// the routes reference the waypoints, so they are only used without route
+	points := decoded.Waypoints
+	if len(decoded.Routes) > 0 {
+		points = nil
+		for _, route := range decoded.Routes {
+			points = append(points, route.Points...)
+		}
+	}
+	result := []waypoint{}
+	for _, point := range points {
+		result = append(result, waypoint{
+			Name: strings.TrimSpace(point.Name),
+			Lat:  point.Lat,
+			Lon:  point.Lon,
+		})
+	}
+	return result, nil
+}
+
+func decodeKML(content []byte) ([]waypoint, error) {
+	decoded := kmlFeature{}
+	if err := xml.Unmarshal(content, &decoded); err != nil {
+		return nil, err
+	}
+
+	result := []waypoint{}
+	for _, placemark := range decoded.placemarks() {
+		// coordinates are lon,lat[,alt]
+		fields := strings.Split(strings.TrimSpace(placemark.Point.Coordinates), ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Invalid coordinates for placemark %s: %s", placemark.Name, placemark.Point.Coordinates)
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, waypoint{
+			Name: strings.TrimSpace(placemark.Name),
+			Lat:  lat,
+			Lon:  lon,
+		})
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// gpx
+////////////////////////////////////////////////////////////////////////////////
+
+type gpx struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []gpxRoute `xml:"rte"`
+}
+
+type gpxRoute struct {
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// kml
+////////////////////////////////////////////////////////////////////////////////
+
+// kmlFeature is any element of the file: the root, a Document, a Folder or a
+// Placemark. The Folders may be nested
+type kmlFeature struct {
+	XMLName  xml.Name
+	Name     string       `xml:"name"`
+	Point    *kmlPoint    `xml:"Point"`
+	Features []kmlFeature `xml:",any"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// placemarks returns the Placemarks with a Point, in the order of the file
+func (f *kmlFeature) placemarks() []*kmlFeature {
+	result := []*kmlFeature{}
+	for i := range f.Features {
+		feature := &f.Features[i]
+		switch feature.XMLName.Local {
+		case "Placemark":
+			if feature.Point != nil {
+				result = append(result, feature)
+			}
+		case "Document", "Folder":
+			result = append(result, feature.placemarks()...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadWaypoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		expected []waypoint
+		err      bool
+	}{
+		{
+			name:     "gpx waypoints",
+			filename: "round.gpx",
+			content: `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+	<wpt lat="48.85" lon="2.35"><name> depot </name></wpt>
+	<wpt lat="48.80" lon="2.13"><name>client</name></wpt>
+</gpx>`,
+			expected: []waypoint{
+				{Name: "depot", Lat: 48.85, Lon: 2.35},
+				{Name: "client", Lat: 48.80, Lon: 2.13},
+			},
+		},
+		{
+			name:     "gpx route",
+			filename: "round.GPX",
+			content: `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+	<wpt lat="48.85" lon="2.35"><name>depot</name></wpt>
+	<wpt lat="48.80" lon="2.13"><name>client</name></wpt>
+	<rte>
+		<rtept lat="48.85" lon="2.35"><name>depot</name></rtept>
+		<rtept lat="48.80" lon="2.13"><name>client</name></rtept>
+		<rtept lat="48.85" lon="2.35"><name>depot</name></rtept>
+	</rte>
+</gpx>`,
+			expected: []waypoint{
+				{Name: "depot", Lat: 48.85, Lon: 2.35},
+				{Name: "client", Lat: 48.80, Lon: 2.13},
+				{Name: "depot", Lat: 48.85, Lon: 2.35},
+			},
+		},
+		{
+			name:     "kml folders",
+			filename: "round.kml",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+	<Document>
+		<name>Round</name>
+		<Placemark><name>depot</name><Point><coordinates>2.35,48.85,0</coordinates></Point></Placemark>
+		<Folder>
+			<name>Morning</name>
+			<Placemark><name>client</name><Point><coordinates> 2.13 , 48.80 </coordinates></Point></Placemark>
+			<Placemark><name>road</name><LineString><coordinates>2.35,48.85 2.13,48.80</coordinates></LineString></Placemark>
+			<Folder>
+				<Placemark><name>bakery</name><Point><coordinates>2.20,48.82</coordinates></Point></Placemark>
+			</Folder>
+		</Folder>
+		<Placemark><Point><coordinates>2.35,48.85</coordinates></Point></Placemark>
+	</Document>
+</kml>`,
+			expected: []waypoint{
+				{Name: "depot", Lat: 48.85, Lon: 2.35},
+				{Name: "client", Lat: 48.80, Lon: 2.13},
+				{Name: "bakery", Lat: 48.82, Lon: 2.20},
+				{Name: "", Lat: 48.85, Lon: 2.35},
+			},
+		},
+		{
+			name:     "kml without document",
+			filename: "round.kml",
+			content: `<kml xmlns="http://www.opengis.net/kml/2.2">
+	<Placemark><name>depot</name><Point><coordinates>2.35,48.85</coordinates></Point></Placemark>
+</kml>`,
+			expected: []waypoint{
+				{Name: "depot", Lat: 48.85, Lon: 2.35},
+			},
+		},
+		{
+			name:     "kml invalid coordinates",
+			filename: "round.kml",
+			content:  `<kml><Placemark><name>depot</name><Point><coordinates>2.35</coordinates></Point></Placemark></kml>`,
+			err:      true,
+		},
+		{
+			name:     "unknown format",
+			filename: "round.json",
+			content:  `{}`,
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), test.filename)
+			if err := os.WriteFile(filename, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := loadWaypoints(filename)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !test.err && !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %+v, expected %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestImportWaypoints(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "round.gpx")
+	content := `<gpx>
+	<rte>
+		<rtept lat="48.85" lon="2.35"><name>depot</name></rtept>
+		<rtept lat="48.80" lon="2.13"></rtept>
+		<rtept lat="48.85" lon="2.35"><name>depot</name></rtept>
+	</rte>
+</gpx>`
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		WaypointsFile: filename,
+		Addresses:     map[string]Address{"depot": {Coordinates: []float64{2.35, 48.85}}},
+	}
+
+	config.importWaypoints()
+	expectedAddresses := map[string]Address{
+		"depot":   {Coordinates: []float64{2.35, 48.85}},
+		"round_1": {Coordinates: []float64{2.13, 48.80}},
+	}
+	if !reflect.DeepEqual(config.Addresses, expectedAddresses) {
+		t.Errorf("got addresses %+v, expected %+v", config.Addresses, expectedAddresses)
+	}
+	expectedPaths := []Path{{From: "depot", To: "round_1"}, {From: "round_1", To: "depot"}}
+	if !reflect.DeepEqual(config.Paths, expectedPaths) {
+		t.Errorf("got paths %+v, expected %+v", config.Paths, expectedPaths)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/trazfr/prometheus-waze-exporter/pkg/waze"
+)
+
+const (
+	what3wordsPrefix = "///"
+	what3wordsURL    = "https://api.what3words.com/v3/convert-to-coordinates"
+)
+
+func isWhat3words(address string) bool {
+	return strings.HasPrefix(strings.TrimSpace(address), what3wordsPrefix)
+}
+
+// What3wordsToQuery resolves an address such as ///filled.count.soap thanks
+// to the what3words API
+func What3wordsToQuery(address string, key string, client *http.Client) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("what3words_key is required to resolve %s", address)
+	}
+	param := url.Values{}
+	param.Set("words", strings.TrimPrefix(strings.TrimSpace(address), what3wordsPrefix))
+	param.Set("key", key)
+
+	logDebug("Call", what3wordsURL, "for", address)
+	resp, err := client.Get(what3wordsURL + "?" + param.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	decodedResponse := what3wordsResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decodedResponse); err != nil {
+		return "", err
+	}
+	if decodedResponse.Error != nil {
+		return "", fmt.Errorf("what3words error %s: %s", decodedResponse.Error.Code, decodedResponse.Error.Message)
+	}
+	if resp.StatusCode != 200 || decodedResponse.Coordinates == nil {
+		return "", fmt.Errorf("Got HTTP %d %s", resp.StatusCode, resp.Status)
+	}
+	return waze.CoordinatesToQuery(decodedResponse.Coordinates.Lng, decodedResponse.Coordinates.Lat), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// what3wordsResponse
+////////////////////////////////////////////////////////////////////////////////
+
+type what3wordsResponse struct {
+	Coordinates *what3wordsCoordinates `json:"coordinates"`
+	Error       *what3wordsError       `json:"error"`
+}
+
+type what3wordsCoordinates struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type what3wordsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}